@@ -0,0 +1,159 @@
+package pongo2
+
+import (
+	"reflect"
+	"sort"
+)
+
+// Node is a node of a compiled template's syntax tree (see Template.Root()).
+// The tree is shared by all executions of a template and must be treated as
+// read-only. Use type assertions on the interfaces below (e. g. TagNode,
+// VariableNode or FilterNode) to find out more about a node.
+type Node interface {
+	// Returns the token the node starts with (nil if unknown)
+	GetPositionToken() *Token
+}
+
+// ParentNode is implemented by all nodes containing other nodes, like the
+// document itself, the body of a tag or an expression.
+//
+// Custom tags registered with RegisterTag() can opt in to the syntax tree by
+// implementing a Children() method on the node returned by their TagParser;
+// the nodes returned are visited by Walk() and Inspect() as children of the
+// tag's TagNode.
+type ParentNode interface {
+	Node
+	Children() []Node
+}
+
+// TagNode is a tag like {% for %} or {% include %}.
+type TagNode interface {
+	ParentNode
+
+	// Name of the tag as registered with RegisterTag()
+	TagName() string
+
+	// The node returned by the tag's parser
+	Tag() INodeTag
+}
+
+// HTMLNode is the plain template content between tags and variables.
+type HTMLNode interface {
+	Node
+	HTML() string
+}
+
+// VariableNode is a reference to a variable (or a function), like user.name
+// or items[0] (which both include the parts accessed).
+type VariableNode interface {
+	Node
+	VariableName() string
+}
+
+// FilterNode is the call of a filter, within a variable or the filter-tag.
+type FilterNode interface {
+	ParentNode
+	FilterName() string
+}
+
+// FileReference is implemented by the nodes of the tags extends, include,
+// import and ssi (see TagNode.Tag()). Filename() returns the resolved name of
+// the referenced template or file, or an empty string if the name is an
+// expression evaluated per execution.
+type FileReference interface {
+	Filename() string
+}
+
+// A Visitor's Visit method is invoked for each node encountered by Walk.
+// If the result visitor w is not nil, Walk visits each of the children
+// of node with the visitor w.
+type Visitor interface {
+	Visit(node Node) (w Visitor)
+}
+
+// Walk traverses the syntax tree in depth-first order: It starts by calling
+// v.Visit(node); if the visitor w returned by v.Visit(node) is not nil, Walk
+// is invoked recursively with visitor w for each of the children of node.
+func Walk(v Visitor, node Node) {
+	if v = v.Visit(node); v == nil {
+		return
+	}
+	if parent, ok := node.(ParentNode); ok {
+		for _, child := range parent.Children() {
+			Walk(v, child)
+		}
+	}
+}
+
+type inspector func(Node) bool
+
+func (f inspector) Visit(node Node) Visitor {
+	if f(node) {
+		return f
+	}
+	return nil
+}
+
+// Inspect traverses the syntax tree in depth-first order: It starts by
+// calling f(node); if f returns true, Inspect invokes f recursively for each
+// of the children of node.
+func Inspect(node Node, f func(Node) bool) {
+	Walk(inspector(f), node)
+}
+
+// Root returns the root node of the template's syntax tree which contains
+// the template's top-level nodes. The tree of a parent template is not
+// included (see FileReference).
+func (tpl *Template) Root() ParentNode {
+	return tpl.root
+}
+
+// appendNodes appends all nodes to children which are part of the syntax
+// tree (nil nodes and internal helpers are skipped). Slices of nodes are
+// appended element by element.
+func appendNodes(children []Node, nodes ...interface{}) []Node {
+	for _, n := range nodes {
+		switch n := n.(type) {
+		case []INode:
+			for _, child := range n {
+				children = appendNodes(children, child)
+			}
+		case []IEvaluator:
+			for _, child := range n {
+				children = appendNodes(children, child)
+			}
+		case []functionCallArgument:
+			for _, child := range n {
+				children = appendNodes(children, child)
+			}
+		case []*filterCall:
+			for _, child := range n {
+				children = appendNodes(children, child)
+			}
+		case []*NodeWrapper:
+			for _, child := range n {
+				children = appendNodes(children, child)
+			}
+		case Node:
+			if v := reflect.ValueOf(n); v.Kind() == reflect.Ptr && v.IsNil() {
+				continue
+			}
+			children = append(children, n)
+		}
+	}
+	return children
+}
+
+// appendNodeMap appends the nodes of a map (like the with-pairs of the
+// tags with and include) ordered by their names.
+func appendNodeMap(children []Node, nodes map[string]IEvaluator) []Node {
+	names := make([]string, 0, len(nodes))
+	for name := range nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		children = appendNodes(children, nodes[name])
+	}
+	return children
+}
@@ -1,6 +1,7 @@
 package pongo2
 
 import (
+	"context"
 	"regexp"
 
 	"github.com/juju/errors"
@@ -64,6 +65,26 @@ func (c Context) Update(other Context) Context {
 // NewChildExecutionContext(parent) function.
 type ExecutionContext struct {
 	template *Template
+	state    *renderState
+
+	// Templates of the current inheritance chain, starting with the
+	// executed root template (see tagBlockNode)
+	inheritance []*Template
+
+	// Set while evaluating expressions which must not fail on undefined
+	// variables (see evaluateIgnoringUndefined)
+	ignoreUndefined bool
+
+	// Marker of the first undefined variable of the variable tag being
+	// rendered (see UndefinedDebug)
+	undefinedMarker string
+
+	// Variables used as truth values within the condition being evaluated;
+	// they must not fail if they're undefined (see evaluateCondition)
+	truthOperands []*variableResolver
+
+	// Set while evaluating the macro call of a call-block (see tagCallNode)
+	caller macroFunction
 
 	Autoescape bool
 	Public     Context
@@ -81,6 +102,11 @@ func newExecutionContext(tpl *Template, ctx Context) *ExecutionContext {
 	// Make the pongo2-related funcs/vars available to the context
 	privateCtx["pongo2"] = pongo2MetaContext
 
+	// namespace() is available unless the context provides its own (see tagSetNode)
+	if _, has := ctx["namespace"]; !has {
+		privateCtx["namespace"] = newNamespace
+	}
+
 	return &ExecutionContext{
 		template: tpl,
 
@@ -92,7 +118,9 @@ func newExecutionContext(tpl *Template, ctx Context) *ExecutionContext {
 
 func NewChildExecutionContext(parent *ExecutionContext) *ExecutionContext {
 	newctx := &ExecutionContext{
-		template: parent.template,
+		template:    parent.template,
+		state:       parent.state,
+		inheritance: parent.inheritance,
 
 		Public:     parent.Public,
 		Private:    make(Context),
@@ -106,12 +134,41 @@ func NewChildExecutionContext(parent *ExecutionContext) *ExecutionContext {
 	return newctx
 }
 
+// nodeState returns the state of the given node within the current execution
+// (it's created using newState on first access). Nodes must not store any state
+// on themselves since a compiled template can be executed concurrently.
+func (ctx *ExecutionContext) nodeState(node INode, newState func() interface{}) interface{} {
+	if ctx.state == nil {
+		ctx.state = newRenderState(ctx.template.set, nil)
+	}
+	if ctx.state.nodeStates == nil {
+		ctx.state.nodeStates = make(map[INode]interface{})
+	}
+	state, has := ctx.state.nodeStates[node]
+	if !has {
+		state = newState()
+		ctx.state.nodeStates[node] = state
+	}
+	return state
+}
+
+// Context returns the context.Context the template is executed with (see
+// Template.ExecuteContext). Use it within your own tags and functions to
+// pass it on to any I/O operations. If the template has been executed without
+// a context.Context, context.Background() is returned.
+func (ctx *ExecutionContext) Context() context.Context {
+	if ctx.state == nil || ctx.state.goCtx == nil {
+		return context.Background()
+	}
+	return ctx.state.goCtx
+}
+
 func (ctx *ExecutionContext) Error(msg string, token *Token) *Error {
 	return ctx.OrigError(errors.New(msg), token)
 }
 
 func (ctx *ExecutionContext) OrigError(err error, token *Token) *Error {
-	filename := ctx.template.name
+	filename := ctx.template.displayName
 	var line, col int
 	if token != nil {
 		// No tokens available
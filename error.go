@@ -3,6 +3,7 @@ package pongo2
 import (
 	"bufio"
 	"fmt"
+	"io"
 	"os"
 )
 
@@ -58,28 +59,46 @@ func (e *Error) Error() string {
 	return s
 }
 
+// Unwrap returns the original error (e. g. for the use with errors.Is and errors.As).
+func (e *Error) Unwrap() error {
+	return e.OrigError
+}
+
 // RawLine returns the affected line from the original template, if available.
 func (e *Error) RawLine() (line string, available bool, outErr error) {
 	if e.Line <= 0 || e.Filename == "<string>" {
 		return "", false, nil
 	}
 
-	filename := e.Filename
+	var r io.Reader
 	if e.Template != nil {
-		filename = e.Template.set.resolveFilename(e.Template, e.Filename)
-	}
-	file, err := os.Open(filename)
-	if err != nil {
-		return "", false, err
-	}
-	defer func() {
-		err := file.Close()
-		if err != nil && outErr == nil {
-			outErr = err
+		// Read the template through the set's loader (which might not
+		// be backed by the local file system at all)
+		name := e.Filename
+		if name == e.Template.displayName {
+			// The name as resolved by the loader
+			name = e.Template.name
 		}
-	}()
+		fd, err := e.Template.set.loader.Get(e.Template.set.resolveFilename(nil, name))
+		if err != nil {
+			return "", false, err
+		}
+		r = fd
+	} else {
+		file, err := os.Open(e.Filename)
+		if err != nil {
+			return "", false, err
+		}
+		defer func() {
+			err := file.Close()
+			if err != nil && outErr == nil {
+				outErr = err
+			}
+		}()
+		r = file
+	}
 
-	scanner := bufio.NewScanner(file)
+	scanner := bufio.NewScanner(r)
 	l := 0
 	for scanner.Scan() {
 		l++
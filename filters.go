@@ -2,6 +2,7 @@ package pongo2
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/juju/errors"
 )
@@ -9,18 +10,86 @@ import (
 // FilterFunction is the type filter functions must fulfil
 type FilterFunction func(in *Value, param *Value) (out *Value, err *Error)
 
-var filters map[string]FilterFunction
+// FilterFunctionWithArgs is the type of filter functions taking several
+// positional and/or keyword arguments, like {{ x|replace("a", "b") }} or
+// {{ x|format_money(currency="EUR", digits=2) }}. They can be called with
+// the classic syntax {{ x|filter:param }} as well (param becomes the only
+// positional argument). Register them using RegisterFilterWithArgs().
+type FilterFunctionWithArgs func(in *Value, args *FilterArguments) (out *Value, err *Error)
+
+// FilterArguments contains the evaluated arguments of a filter call.
+type FilterArguments struct {
+	Positional []*Value
+	Keyword    map[string]*Value
+}
+
+// Arg returns the keyword argument with the given name or, if there's none,
+// the positional argument at idx. NIL is returned if neither exists.
+func (args *FilterArguments) Arg(idx int, name string) *Value {
+	if v, has := args.Keyword[name]; has {
+		return v
+	}
+	if idx >= 0 && idx < len(args.Positional) {
+		return args.Positional[idx]
+	}
+	return AsValue(nil)
+}
+
+type filter struct {
+	name       string
+	fn         FilterFunction
+	fnWithArgs FilterFunctionWithArgs
+}
+
+func (f *filter) call(in *Value, args *FilterArguments) (*Value, *Error) {
+	if f.fnWithArgs != nil {
+		return f.fnWithArgs(in, args)
+	}
+	return f.fn(in, args.Arg(0, ""))
+}
+
+var (
+	filters      map[string]*filter
+	filtersMutex sync.RWMutex
+)
 
 func init() {
-	filters = make(map[string]FilterFunction)
+	filters = make(map[string]*filter)
 }
 
 // FilterExists returns true if the given filter is already registered
 func FilterExists(name string) bool {
-	_, existing := filters[name]
+	_, existing := getFilter(name)
 	return existing
 }
 
+func getFilter(name string) (*filter, bool) {
+	filtersMutex.RLock()
+	defer filtersMutex.RUnlock()
+	f, existing := filters[name]
+	return f, existing
+}
+
+func registerFilter(name string, f *filter) error {
+	filtersMutex.Lock()
+	defer filtersMutex.Unlock()
+	if _, existing := filters[name]; existing {
+		return errors.Errorf("filter with name '%s' is already registered", name)
+	}
+	filters[name] = f
+	return nil
+}
+
+func replaceFilter(name string, f *filter) error {
+	filtersMutex.Lock()
+	defer filtersMutex.Unlock()
+	if _, existing := filters[name]; !existing {
+		return errors.Errorf("filter with name '%s' does not exist (therefore cannot be overridden)", name)
+	}
+	filters[name] = f
+	return nil
+}
+
 // RegisterFilter registers a new filter. If there's already a filter with the same
 // name, RegisterFilter will panic. You usually want to call this
 // function in the filter's init() function:
@@ -29,21 +98,25 @@ func FilterExists(name string) bool {
 // See http://www.florian-schlachter.de/post/pongo2/ for more about
 // writing filters and tags.
 func RegisterFilter(name string, fn FilterFunction) error {
-	if FilterExists(name) {
-		return errors.Errorf("filter with name '%s' is already registered", name)
-	}
-	filters[name] = fn
-	return nil
+	return registerFilter(name, &filter{name: name, fn: fn})
+}
+
+// RegisterFilterWithArgs registers a new filter taking positional and keyword
+// arguments (see FilterFunctionWithArgs). Same rules as for RegisterFilter apply.
+func RegisterFilterWithArgs(name string, fn FilterFunctionWithArgs) error {
+	return registerFilter(name, &filter{name: name, fnWithArgs: fn})
 }
 
 // ReplaceFilter replaces an already registered filter with a new implementation. Use this
 // function with caution since it allows you to change existing filter behaviour.
 func ReplaceFilter(name string, fn FilterFunction) error {
-	if !FilterExists(name) {
-		return errors.Errorf("filter with name '%s' does not exist (therefore cannot be overridden)", name)
-	}
-	filters[name] = fn
-	return nil
+	return replaceFilter(name, &filter{name: name, fn: fn})
+}
+
+// ReplaceFilterWithArgs works like ReplaceFilter, but for filters
+// taking positional and keyword arguments.
+func ReplaceFilterWithArgs(name string, fn FilterFunctionWithArgs) error {
+	return replaceFilter(name, &filter{name: name, fnWithArgs: fn})
 }
 
 // MustApplyFilter behaves like ApplyFilter, but panics on an error.
@@ -58,7 +131,7 @@ func MustApplyFilter(name string, value *Value, param *Value) *Value {
 // ApplyFilter applies a filter to a given value using the given parameters.
 // Returns a *pongo2.Value or an error.
 func ApplyFilter(name string, value *Value, param *Value) (*Value, *Error) {
-	fn, existing := filters[name]
+	f, existing := getFilter(name)
 	if !existing {
 		return nil, &Error{
 			Sender:    "applyfilter",
@@ -66,37 +139,81 @@ func ApplyFilter(name string, value *Value, param *Value) (*Value, *Error) {
 		}
 	}
 
-	// Make sure param is a *Value
-	if param == nil {
-		param = AsValue(nil)
+	args := &FilterArguments{}
+	if param != nil {
+		args.Positional = []*Value{param}
 	}
+	return f.call(value, args)
+}
 
-	return fn(value, param)
+// escape applies the escape filter of the template's set to value
+// (used for autoescaping).
+func (ctx *ExecutionContext) escape(value *Value) (*Value, *Error) {
+	f, existing := ctx.template.set.getFilter("escape")
+	if !existing {
+		return nil, &Error{
+			Sender:    "applyfilter",
+			OrigError: errors.Errorf("Filter with name 'escape' not found."),
+		}
+	}
+	return f.call(value, &FilterArguments{})
 }
 
 type filterCall struct {
 	token *Token
 
-	name      string
-	parameter IEvaluator
+	name string
+
+	// Either the parameter of the classic syntax (filter:param) or the
+	// arguments of the call syntax (filter(arg, ..., key=arg, ...))
+	parameter     IEvaluator
+	args          []IEvaluator
+	kwargNames    []string
+	kwargs        []IEvaluator
+	filterWrapper *filter
+}
+
+func (fc *filterCall) GetPositionToken() *Token {
+	return fc.token
+}
+
+func (fc *filterCall) FilterName() string {
+	return fc.name
+}
 
-	filterFunc FilterFunction
+func (fc *filterCall) Children() []Node {
+	return appendNodes(nil, fc.parameter, fc.args, fc.kwargs)
 }
 
 func (fc *filterCall) Execute(v *Value, ctx *ExecutionContext) (*Value, *Error) {
-	var param *Value
-	var err *Error
+	args := &FilterArguments{}
 
 	if fc.parameter != nil {
-		param, err = fc.parameter.Evaluate(ctx)
+		param, err := fc.parameter.Evaluate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		args.Positional = []*Value{param}
+	}
+	for _, arg := range fc.args {
+		value, err := arg.Evaluate(ctx)
 		if err != nil {
 			return nil, err
 		}
-	} else {
-		param = AsValue(nil)
+		args.Positional = append(args.Positional, value)
+	}
+	if len(fc.kwargs) > 0 {
+		args.Keyword = make(map[string]*Value, len(fc.kwargs))
+		for idx, kwarg := range fc.kwargs {
+			value, err := kwarg.Evaluate(ctx)
+			if err != nil {
+				return nil, err
+			}
+			args.Keyword[fc.kwargNames[idx]] = value
+		}
 	}
 
-	filteredValue, err := fc.filterFunc(v, param)
+	filteredValue, err := fc.filterWrapper.call(v, args)
 	if err != nil {
 		return nil, err.updateFromTokenIfNeeded(ctx.template, fc.token)
 	}
@@ -118,21 +235,62 @@ func (p *Parser) parseFilter() (*filterCall, *Error) {
 	}
 
 	// Get the appropriate filter function and bind it
-	filterFn, exists := filters[identToken.Val]
+	f, exists := p.template.set.getFilter(identToken.Val)
 	if !exists {
 		return nil, p.Error(fmt.Sprintf("Filter '%s' does not exist.", identToken.Val), identToken)
 	}
 
-	filter.filterFunc = filterFn
+	filter.filterWrapper = f
+
+	// Check for filter-arguments: '(' [ARG {',' ARG}] [IDENT '=' ARG {',' IDENT '=' ARG}] ')'
+	if p.Match(TokenSymbol, "(") != nil {
+		for p.Match(TokenSymbol, ")") == nil {
+			if p.Remaining() == 0 {
+				return nil, p.Error("Unexpected EOF, expected ')' to close the filter arguments.", p.lastToken)
+			}
 
-	// Check for filter-argument (2 tokens needed: ':' ARG)
-	if p.Match(TokenSymbol, ":") != nil {
+			if p.PeekType(TokenIdentifier) != nil && p.PeekN(1, TokenSymbol, "=") != nil {
+				// Keyword argument
+				nameToken := p.Current()
+				p.ConsumeN(2)
+				for _, name := range filter.kwargNames {
+					if name == nameToken.Val {
+						return nil, p.Error(fmt.Sprintf("Keyword argument '%s' given twice.", name), nameToken)
+					}
+				}
+				arg, err := p.ParseExpression()
+				if err != nil {
+					return nil, err
+				}
+				filter.kwargNames = append(filter.kwargNames, nameToken.Val)
+				filter.kwargs = append(filter.kwargs, arg)
+			} else {
+				if len(filter.kwargs) > 0 {
+					return nil, p.Error("Positional arguments must not follow keyword arguments.", nil)
+				}
+				arg, err := p.ParseExpression()
+				if err != nil {
+					return nil, err
+				}
+				filter.args = append(filter.args, arg)
+			}
+
+			if p.Match(TokenSymbol, ",") == nil && p.Peek(TokenSymbol, ")") == nil {
+				return nil, p.Error("Expected ',' or ')' after filter argument.", nil)
+			}
+		}
+
+		if f.fnWithArgs == nil && (len(filter.args) > 1 || len(filter.kwargs) > 0) {
+			return nil, p.Error(fmt.Sprintf("Filter '%s' takes at most one positional argument.", identToken.Val), identToken)
+		}
+	} else if p.Match(TokenSymbol, ":") != nil {
+		// Check for filter-argument (2 tokens needed: ':' ARG)
 		if p.Peek(TokenSymbol, "}}") != nil {
 			return nil, p.Error("Filter parameter required after ':'.", nil)
 		}
 
 		// Get filter argument expression
-		v, err := p.parseVariableOrLiteral()
+		v, err := p.parseFilterArgument()
 		if err != nil {
 			return nil, err
 		}
@@ -141,3 +299,13 @@ func (p *Parser) parseFilter() (*filterCall, *Error) {
 
 	return filter, nil
 }
+
+// parseFilterArgument parses a variable or literal as a filter argument. Full
+// expressions must be enclosed in parentheses (e. g. default:(a if b else c)),
+// otherwise the following filters would become part of the argument.
+func (p *Parser) parseFilterArgument() (IEvaluator, *Error) {
+	if p.Peek(TokenSymbol, "(") != nil {
+		return p.parseFactor()
+	}
+	return p.parseVariableOrLiteral()
+}
@@ -70,7 +70,7 @@ func init() {
 	RegisterFilter("lower", filterLower)
 	RegisterFilter("make_list", filterMakelist)
 	RegisterFilter("phone2numeric", filterPhone2numeric)
-	RegisterFilter("pluralize", filterPluralize)
+	RegisterFilterWithArgs("pluralize", filterPluralize)
 	RegisterFilter("random", filterRandom)
 	RegisterFilter("removetags", filterRemovetags)
 	RegisterFilter("rjust", filterRjust)
@@ -90,10 +90,12 @@ func init() {
 	RegisterFilter("urlizetrunc", filterUrlizetrunc)
 	RegisterFilter("wordcount", filterWordcount)
 	RegisterFilter("wordwrap", filterWordwrap)
-	RegisterFilter("yesno", filterYesno)
+	RegisterFilterWithArgs("yesno", filterYesno)
 
 	RegisterFilter("float", filterFloat)     // pongo-specific
 	RegisterFilter("integer", filterInteger) // pongo-specific
+
+	RegisterFilterWithArgs("replace", filterReplace) // pongo-specific
 }
 
 func filterTruncatecharsHelper(s string, newLen int) string {
@@ -764,11 +766,27 @@ func filterPhone2numeric(in *Value, param *Value) (*Value, *Error) {
 	return AsValue(sin), nil
 }
 
-func filterPluralize(in *Value, param *Value) (*Value, *Error) {
+// filterOptions returns the options passed to a filter either as separate
+// arguments (like yesno("yes", "no")) or as a comma-separated string
+// (like yesno:"yes,no").
+func filterOptions(args *FilterArguments) []string {
+	if len(args.Positional) == 1 {
+		if args.Positional[0].Len() == 0 {
+			return nil
+		}
+		return strings.Split(args.Positional[0].String(), ",")
+	}
+	options := make([]string, 0, len(args.Positional))
+	for _, arg := range args.Positional {
+		options = append(options, arg.String())
+	}
+	return options
+}
+
+func filterPluralize(in *Value, args *FilterArguments) (*Value, *Error) {
 	if in.IsNumber() {
 		// Works only on numbers
-		if param.Len() > 0 {
-			endings := strings.Split(param.String(), ",")
+		if endings := filterOptions(args); len(endings) > 0 {
 			if len(endings) > 2 {
 				return nil, &Error{
 					Sender:    "filter:pluralize",
@@ -882,15 +900,15 @@ func filterWordwrap(in *Value, param *Value) (*Value, *Error) {
 	return AsValue(strings.Join(lines, "\n")), nil
 }
 
-func filterYesno(in *Value, param *Value) (*Value, *Error) {
+func filterYesno(in *Value, args *FilterArguments) (*Value, *Error) {
 	choices := map[int]string{
 		0: "yes",
 		1: "no",
 		2: "maybe",
 	}
-	paramString := param.String()
-	customChoices := strings.Split(paramString, ",")
-	if len(paramString) > 0 {
+	customChoices := filterOptions(args)
+	paramString := strings.Join(customChoices, ",")
+	if len(customChoices) > 0 {
 		if len(customChoices) > 3 {
 			return nil, &Error{
 				Sender:    "filter:yesno",
@@ -925,3 +943,17 @@ func filterYesno(in *Value, param *Value) (*Value, *Error) {
 	// no
 	return AsValue(choices[1]), nil
 }
+
+func filterReplace(in *Value, args *FilterArguments) (*Value, *Error) {
+	if args.Arg(0, "old").IsNil() || args.Arg(1, "new").IsNil() {
+		return nil, &Error{
+			Sender:    "filter:replace",
+			OrigError: errors.New("filter 'replace' requires the arguments old and new (like replace(\"a\", \"b\"))"),
+		}
+	}
+	count := -1
+	if c := args.Arg(2, "count"); !c.IsNil() {
+		count = c.Integer()
+	}
+	return AsValue(strings.Replace(in.String(), args.Arg(0, "old").String(), args.Arg(1, "new").String(), count)), nil
+}
@@ -35,7 +35,7 @@ var (
 		"==", ">=", "<=", "&&", "||", "{{", "}}", "{%", "%}", "!=", "<>",
 
 		// 1-Char symbol
-		"(", ")", "+", "-", "*", "<", ">", "/", "^", ",", ".", "!", "|", ":", "=", "%",
+		"(", ")", "+", "-", "*", "<", ">", "/", "^", ",", ".", "!", "|", ":", "=", "%", "[", "]", "{", "}",
 	}
 
 	// Available keywords in pongo2
@@ -67,6 +67,14 @@ type lexer struct {
 
 	inVerbatim   bool
 	verbatimName string
+
+	// Whitespace control
+	trimBlocks   bool // remove the first newline after a tag/comment
+	lstripBlocks bool // remove spaces/tabs from the start of a line up to a tag/comment
+	trimNext     bool // the last tag/variable/comment ended with '-'
+	lastDelim    string
+
+	braces int // open braces of map literals within the current tag/variable
 }
 
 func (t *Token) String() string {
@@ -99,15 +107,17 @@ func (t *Token) String() string {
 		typ, t.Typ, val, t.Line, t.Col)
 }
 
-func lex(name string, input string) ([]*Token, *Error) {
+func lex(name string, input string, set *TemplateSet) ([]*Token, *Error) {
 	l := &lexer{
-		name:      name,
-		input:     input,
-		tokens:    make([]*Token, 0, 100),
-		line:      1,
-		col:       1,
-		startline: 1,
-		startcol:  1,
+		name:         name,
+		input:        input,
+		tokens:       make([]*Token, 0, 100),
+		line:         1,
+		col:          1,
+		startline:    1,
+		startcol:     1,
+		trimBlocks:   set.TrimBlocks,
+		lstripBlocks: set.LstripBlocks,
 	}
 	l.run()
 	if l.errored {
@@ -152,6 +162,66 @@ func (l *lexer) emit(t TokenType) {
 	l.startcol = l.col
 }
 
+// emitHTML emits the pending HTML text after the whitespace in front of
+// the current tag, variable or comment has been stripped (if requested).
+func (l *lexer) emitHTML() {
+	if l.pos <= l.start {
+		return
+	}
+
+	text := l.input[l.start:l.pos]
+	rest := l.input[l.pos:]
+	if strings.HasPrefix(rest[2:], "-") {
+		// {%- ... %}, {{- ... }} and {#- ... #}
+		text = strings.TrimRight(text, tokenSpaceChars)
+	} else if l.lstripBlocks && !strings.HasPrefix(rest, "{{") {
+		// Only strip if the tag/comment is the first thing on its line
+		lineStart := strings.LastIndex(text, "\n") + 1
+		if lineStart > 0 || l.start == 0 || l.input[l.start-1] == '\n' {
+			if strings.Trim(text[lineStart:], " \t") == "" {
+				text = text[:lineStart]
+			}
+		}
+	}
+
+	if text != "" {
+		l.tokens = append(l.tokens, &Token{
+			Filename: l.name,
+			Typ:      TokenHTML,
+			Val:      text,
+			Line:     l.startline,
+			Col:      l.startcol,
+		})
+	}
+	l.ignore()
+}
+
+// skipWhitespace skips the whitespace after a tag, variable or
+// comment if requested (using '-' or TrimBlocks).
+func (l *lexer) skipWhitespace() {
+	switch {
+	case l.trimNext:
+		for l.pos < len(l.input) && strings.IndexByte(tokenSpaceChars, l.input[l.pos]) >= 0 {
+			if l.input[l.pos] == '\n' {
+				l.line++
+				l.col = 0
+			}
+			l.next()
+		}
+	case l.trimBlocks && l.lastDelim != "}}":
+		if strings.HasPrefix(l.input[l.pos:], "\r\n") {
+			l.next()
+		}
+		if strings.HasPrefix(l.input[l.pos:], "\n") {
+			l.line++
+			l.col = 0
+			l.next()
+		}
+	}
+	l.trimNext = false
+	l.ignore()
+}
+
 func (l *lexer) next() rune {
 	if l.pos >= len(l.input) {
 		l.width = 0
@@ -247,9 +317,7 @@ func (l *lexer) run() {
 		if !l.inVerbatim {
 			// Ignore single-line comments {# ... #}
 			if strings.HasPrefix(l.input[l.pos:], "{#") {
-				if l.pos > l.start {
-					l.emit(TokenHTML)
-				}
+				l.emitHTML()
 
 				l.pos += 2 // pass '{#'
 				l.col += 2
@@ -264,6 +332,11 @@ func (l *lexer) run() {
 						return
 					}
 
+					if strings.HasPrefix(l.input[l.pos:], "-#}") {
+						l.pos++ // pass '-'
+						l.col++
+						l.trimNext = true
+					}
 					if strings.HasPrefix(l.input[l.pos:], "#}") {
 						l.pos += 2 // pass '#}'
 						l.col += 2
@@ -272,7 +345,8 @@ func (l *lexer) run() {
 
 					l.next()
 				}
-				l.ignore() // ignore whole comment
+				l.lastDelim = "#}"
+				l.skipWhitespace() // ignore whole comment
 
 				// Comment skipped
 				continue // next token
@@ -280,13 +354,12 @@ func (l *lexer) run() {
 
 			if strings.HasPrefix(l.input[l.pos:], "{{") || // variable
 				strings.HasPrefix(l.input[l.pos:], "{%") { // tag
-				if l.pos > l.start {
-					l.emit(TokenHTML)
-				}
+				l.emitHTML()
 				l.tokenize()
 				if l.errored {
 					return
 				}
+				l.skipWhitespace()
 				continue
 			}
 		}
@@ -311,6 +384,7 @@ func (l *lexer) run() {
 }
 
 func (l *lexer) tokenize() {
+	l.braces = 0
 	for state := l.stateCode; state != nil; {
 		state = state()
 	}
@@ -334,18 +408,45 @@ outer_loop:
 			return l.stateString
 		}
 
+		// Whitespace control: -%} and -}}
+		if strings.HasPrefix(l.input[l.start:], "-%}") || strings.HasPrefix(l.input[l.start:], "-}}") {
+			l.next()
+			l.ignore()
+			l.trimNext = true
+		}
+
 		// Check for symbol
 		for _, sym := range TokenSymbols {
 			if strings.HasPrefix(l.input[l.start:], sym) {
+				switch sym {
+				case "}}":
+					if l.braces > 0 {
+						// Closing brace of a (nested) map literal
+						sym = "}"
+						l.braces--
+					}
+				case "{":
+					l.braces++
+				case "}":
+					l.braces--
+				}
+
 				l.pos += len(sym)
 				l.col += l.length()
 				l.emit(TokenSymbol)
 
 				if sym == "%}" || sym == "}}" {
 					// Tag/variable end, return after emit
+					l.lastDelim = sym
 					return nil
 				}
 
+				if (sym == "{%" || sym == "{{") && l.peek() == '-' {
+					// Whitespace control: {%- and {{- (already handled by emitHTML)
+					l.next()
+					l.ignore()
+				}
+
 				continue outer_loop
 			}
 		}
@@ -0,0 +1,158 @@
+package pongo2
+
+import (
+	"context"
+	"fmt"
+)
+
+// defaultMaxRecursionDepth is used if TemplateSet.MaxRecursionDepth is 0;
+// it protects against infinitely recursing templates (which would otherwise
+// crash the program with a stack overflow).
+const defaultMaxRecursionDepth = 1000
+
+// LimitError is used as the OrigError of an *Error whenever a template
+// execution exceeds one of the resource limits of its TemplateSet
+// (see TemplateSet.MaxOutputBytes and friends).
+type LimitError struct {
+	Limit string // name of the exceeded limit, e. g. "output bytes"
+	Max   int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("limit of %d %s exceeded", e.Max, e.Limit)
+}
+
+// renderState holds the state of one template execution. It's shared by all
+// ExecutionContexts of an execution, including the ones of included templates.
+type renderState struct {
+	set   *TemplateSet
+	goCtx context.Context
+
+	outputBytes    int
+	loopIterations int
+	nodes          int
+	depth          int
+
+	// The first exceeded limit; once set, the execution is aborted
+	err *Error
+
+	// State of nodes within this execution (see ExecutionContext.nodeState)
+	nodeStates map[INode]interface{}
+}
+
+func newRenderState(set *TemplateSet, goCtx context.Context) *renderState {
+	return &renderState{
+		set:   set,
+		goCtx: goCtx,
+	}
+}
+
+// limitedTemplateWriter counts the written bytes and discards
+// everything exceeding the TemplateSet's MaxOutputBytes.
+type limitedTemplateWriter struct {
+	w     TemplateWriter
+	state *renderState
+}
+
+func (lw *limitedTemplateWriter) WriteString(s string) (int, error) {
+	return lw.Write([]byte(s))
+}
+
+func (lw *limitedTemplateWriter) Write(b []byte) (int, error) {
+	if lw.state.err != nil {
+		return 0, lw.state.err
+	}
+	max := lw.state.set.MaxOutputBytes
+	if lw.state.outputBytes+len(b) > max {
+		lw.state.err = &Error{
+			Sender:    "execution",
+			OrigError: &LimitError{Limit: "output bytes", Max: max},
+		}
+		return 0, lw.state.err
+	}
+	lw.state.outputBytes += len(b)
+	return lw.w.Write(b)
+}
+
+// limitError records (and returns) an exceeded limit; the execution is aborted
+// before the next node is executed.
+func (ctx *ExecutionContext) limitError(limit string, max int, token *Token) *Error {
+	if ctx.state.err == nil {
+		ctx.state.err = ctx.OrigError(&LimitError{Limit: limit, Max: max}, token)
+	}
+	return ctx.state.err
+}
+
+// checkLimits is called before each node gets executed. It returns an
+// error if the execution has to be aborted (see checkAborted) or if the
+// set's MaxNodes has been exceeded.
+func (ctx *ExecutionContext) checkLimits(node INode) *Error {
+	token := nodePosition(node)
+	if err := ctx.checkAborted(token); err != nil {
+		return err
+	}
+
+	ctx.state.nodes++
+	if max := ctx.state.set.MaxNodes; max > 0 && ctx.state.nodes > max {
+		return ctx.limitError("nodes", max, token)
+	}
+	return nil
+}
+
+// checkAborted returns an error if any limit has been exceeded or if the
+// execution's context.Context has been canceled or its deadline has been exceeded.
+func (ctx *ExecutionContext) checkAborted(token *Token) *Error {
+	state := ctx.state
+	if state.err != nil {
+		if state.err.Template == nil {
+			// The output limit has been exceeded by the writer; add the
+			// position of the node which would have been executed next
+			state.err = ctx.OrigError(state.err.OrigError, token)
+		}
+		return state.err
+	}
+
+	if state.goCtx != nil {
+		if err := state.goCtx.Err(); err != nil {
+			return ctx.OrigError(err, token)
+		}
+	}
+	return nil
+}
+
+// addLoopIterations accounts for n loop iterations (e. g. of a for-loop).
+func (ctx *ExecutionContext) addLoopIterations(n int, token *Token) *Error {
+	ctx.state.loopIterations += n
+	if max := ctx.state.set.MaxLoopIterations; max > 0 && ctx.state.loopIterations > max {
+		return ctx.limitError("loop iterations", max, token)
+	}
+	return nil
+}
+
+// enter must be called before executing an include or a macro; leave must be
+// called afterwards (if enter succeeded).
+func (ctx *ExecutionContext) enter(token *Token) *Error {
+	max := ctx.state.set.MaxRecursionDepth
+	if max <= 0 {
+		max = defaultMaxRecursionDepth
+	}
+	if ctx.state.depth >= max {
+		return ctx.limitError("recursion depth", max, token)
+	}
+	ctx.state.depth++
+	return nil
+}
+
+func (ctx *ExecutionContext) leave() {
+	ctx.state.depth--
+}
+
+// nodePosition returns the position of the node (if available).
+func nodePosition(node INode) *Token {
+	if p, ok := node.(interface {
+		GetPositionToken() *Token
+	}); ok {
+		return p.GetPositionToken()
+	}
+	return nil
+}
@@ -7,6 +7,9 @@ type nodeDocument struct {
 
 func (doc *nodeDocument) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	for _, n := range doc.Nodes {
+		if err := ctx.checkLimits(n); err != nil {
+			return err
+		}
 		err := n.Execute(ctx, writer)
 		if err != nil {
 			return err
@@ -14,3 +17,14 @@ func (doc *nodeDocument) Execute(ctx *ExecutionContext, writer TemplateWriter) *
 	}
 	return nil
 }
+
+func (doc *nodeDocument) GetPositionToken() *Token {
+	if len(doc.Nodes) > 0 {
+		return nodePosition(doc.Nodes[0])
+	}
+	return nil
+}
+
+func (doc *nodeDocument) Children() []Node {
+	return appendNodes(nil, doc.Nodes)
+}
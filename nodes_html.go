@@ -8,3 +8,11 @@ func (n *nodeHTML) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error
 	writer.WriteString(n.token.Val)
 	return nil
 }
+
+func (n *nodeHTML) GetPositionToken() *Token {
+	return n.token
+}
+
+func (n *nodeHTML) HTML() string {
+	return n.token.Val
+}
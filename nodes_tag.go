@@ -0,0 +1,32 @@
+package pongo2
+
+// A tag of the template; wraps the node returned by the tag's parser
+type nodeTag struct {
+	token *Token // the tag's name
+	tag   INodeTag
+}
+
+func (n *nodeTag) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
+	return n.tag.Execute(ctx, writer)
+}
+
+func (n *nodeTag) GetPositionToken() *Token {
+	return n.token
+}
+
+func (n *nodeTag) TagName() string {
+	return n.token.Val
+}
+
+func (n *nodeTag) Tag() INodeTag {
+	return n.tag
+}
+
+func (n *nodeTag) Children() []Node {
+	if parent, ok := n.tag.(interface {
+		Children() []Node
+	}); ok {
+		return parent.Children()
+	}
+	return nil
+}
@@ -7,6 +7,9 @@ type NodeWrapper struct {
 
 func (wrapper *NodeWrapper) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	for _, n := range wrapper.nodes {
+		if err := ctx.checkLimits(n); err != nil {
+			return err
+		}
 		err := n.Execute(ctx, writer)
 		if err != nil {
 			return err
@@ -14,3 +17,17 @@ func (wrapper *NodeWrapper) Execute(ctx *ExecutionContext, writer TemplateWriter
 	}
 	return nil
 }
+
+// GetPositionToken returns the position of the first wrapped node
+// (nil if the wrapper is empty).
+func (wrapper *NodeWrapper) GetPositionToken() *Token {
+	if len(wrapper.nodes) > 0 {
+		return nodePosition(wrapper.nodes[0])
+	}
+	return nil
+}
+
+// Children returns the wrapped nodes.
+func (wrapper *NodeWrapper) Children() []Node {
+	return appendNodes(nil, wrapper.nodes)
+}
@@ -36,6 +36,10 @@ type Parser struct {
 	// if the parser parses a template document, here will be
 	// a reference to it (needed to access the template through Tags)
 	template *Template
+
+	// Number of enclosing for-loops of the currently parsed tag
+	// (used to validate the break- and continue-tags)
+	loopDepth int
 }
 
 // Creates a new parser to parse tokens.
@@ -240,7 +244,7 @@ func (p *Parser) WrapUntilTag(names ...string) (*NodeWrapper, *Parser, *Error) {
 						if p.Match(TokenSymbol, "%}") != nil {
 							// Okay, end the wrapping here
 							wrapper.Endtag = tagIdent.Val
-							return wrapper, newParser(p.template.name, tagArgs, p.template), nil
+							return wrapper, newParser(p.template.displayName, tagArgs, p.template), nil
 						}
 						t := p.Current()
 						p.Consume()
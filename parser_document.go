@@ -30,7 +30,7 @@ func (p *Parser) parseDocElement() (INode, *Error) {
 }
 
 func (tpl *Template) parse() *Error {
-	tpl.parser = newParser(tpl.name, tpl.tokens, tpl)
+	tpl.parser = newParser(tpl.displayName, tpl.tokens, tpl)
 	doc, err := tpl.parser.parseDocument()
 	if err != nil {
 		return err
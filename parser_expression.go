@@ -17,6 +17,11 @@ type relationalExpression struct {
 	expr1   IEvaluator
 	expr2   IEvaluator
 	opToken *Token
+
+	// Only for tests: expr1 is [not] test(testArgs...)
+	test       *test
+	testArgs   []IEvaluator
+	testNegate bool
 }
 
 type simpleExpression struct {
@@ -40,6 +45,26 @@ type power struct {
 	power2 IEvaluator
 }
 
+// expr1 if condition else expr2
+type conditionalExpression struct {
+	condition IEvaluator
+	expr1     IEvaluator
+	expr2     IEvaluator
+}
+
+// [expr, expr, ...]
+type listLiteral struct {
+	locationToken *Token
+	items         []IEvaluator
+}
+
+// {expr: expr, ...}
+type mapLiteral struct {
+	locationToken *Token
+	keys          []IEvaluator
+	values        []IEvaluator
+}
+
 func (expr *Expression) FilterApplied(name string) bool {
 	return expr.expr1.FilterApplied(name) && (expr.expr2 == nil ||
 		(expr.expr2 != nil && expr.expr2.FilterApplied(name)))
@@ -65,6 +90,18 @@ func (expr *power) FilterApplied(name string) bool {
 		(expr.power2 != nil && expr.power2.FilterApplied(name)))
 }
 
+func (expr *conditionalExpression) FilterApplied(name string) bool {
+	return expr.expr1.FilterApplied(name) && expr.expr2.FilterApplied(name)
+}
+
+func (l *listLiteral) FilterApplied(name string) bool {
+	return false
+}
+
+func (m *mapLiteral) FilterApplied(name string) bool {
+	return false
+}
+
 func (expr *Expression) GetPositionToken() *Token {
 	return expr.expr1.GetPositionToken()
 }
@@ -85,6 +122,54 @@ func (expr *power) GetPositionToken() *Token {
 	return expr.power1.GetPositionToken()
 }
 
+func (expr *conditionalExpression) GetPositionToken() *Token {
+	return expr.expr1.GetPositionToken()
+}
+
+func (l *listLiteral) GetPositionToken() *Token {
+	return l.locationToken
+}
+
+func (m *mapLiteral) GetPositionToken() *Token {
+	return m.locationToken
+}
+
+func (expr *Expression) Children() []Node {
+	return appendNodes(nil, expr.expr1, expr.expr2)
+}
+
+func (expr *relationalExpression) Children() []Node {
+	return appendNodes(nil, expr.expr1, expr.expr2, expr.testArgs)
+}
+
+func (expr *simpleExpression) Children() []Node {
+	return appendNodes(nil, expr.term1, expr.term2)
+}
+
+func (expr *term) Children() []Node {
+	return appendNodes(nil, expr.factor1, expr.factor2)
+}
+
+func (expr *power) Children() []Node {
+	return appendNodes(nil, expr.power1, expr.power2)
+}
+
+func (expr *conditionalExpression) Children() []Node {
+	return appendNodes(nil, expr.expr1, expr.condition, expr.expr2)
+}
+
+func (l *listLiteral) Children() []Node {
+	return appendNodes(nil, l.items)
+}
+
+func (m *mapLiteral) Children() []Node {
+	var children []Node
+	for idx, key := range m.keys {
+		children = appendNodes(children, key, m.values[idx])
+	}
+	return children
+}
+
 func (expr *Expression) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	value, err := expr.Evaluate(ctx)
 	if err != nil {
@@ -130,6 +215,73 @@ func (expr *power) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error
 	return nil
 }
 
+func (expr *conditionalExpression) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
+	value, err := expr.Evaluate(ctx)
+	if err != nil {
+		return err
+	}
+	writer.WriteString(value.String())
+	return nil
+}
+
+func (l *listLiteral) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
+	value, err := l.Evaluate(ctx)
+	if err != nil {
+		return err
+	}
+	writer.WriteString(value.String())
+	return nil
+}
+
+func (m *mapLiteral) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
+	value, err := m.Evaluate(ctx)
+	if err != nil {
+		return err
+	}
+	writer.WriteString(value.String())
+	return nil
+}
+
+func (expr *conditionalExpression) Evaluate(ctx *ExecutionContext) (*Value, *Error) {
+	condition, err := expr.condition.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// Only the taken branch gets evaluated
+	if condition.IsTrue() {
+		return expr.expr1.Evaluate(ctx)
+	}
+	return expr.expr2.Evaluate(ctx)
+}
+
+func (l *listLiteral) Evaluate(ctx *ExecutionContext) (*Value, *Error) {
+	items := make([]interface{}, 0, len(l.items))
+	for _, item := range l.items {
+		v, err := item.Evaluate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, v.Interface())
+	}
+	return AsValue(items), nil
+}
+
+func (m *mapLiteral) Evaluate(ctx *ExecutionContext) (*Value, *Error) {
+	items := make(map[string]interface{}, len(m.keys))
+	for idx, key := range m.keys {
+		k, err := key.Evaluate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		v, err := m.values[idx].Evaluate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		items[k.String()] = v.Interface()
+	}
+	return AsValue(items), nil
+}
+
 func (expr *Expression) Evaluate(ctx *ExecutionContext) (*Value, *Error) {
 	v1, err := expr.expr1.Evaluate(ctx)
 	if err != nil {
@@ -154,6 +306,10 @@ func (expr *Expression) Evaluate(ctx *ExecutionContext) (*Value, *Error) {
 }
 
 func (expr *relationalExpression) Evaluate(ctx *ExecutionContext) (*Value, *Error) {
+	if expr.test != nil {
+		return expr.evaluateTest(ctx)
+	}
+
 	v1, err := expr.expr1.Evaluate(ctx)
 	if err != nil {
 		return nil, err
@@ -198,6 +354,29 @@ func (expr *relationalExpression) Evaluate(ctx *ExecutionContext) (*Value, *Erro
 	}
 }
 
+func (expr *relationalExpression) evaluateTest(ctx *ExecutionContext) (*Value, *Error) {
+	// Tests like 'defined' must be able to see undefined variables
+	v, err := ctx.evaluateIgnoringUndefined(expr.expr1)
+	if err != nil {
+		return nil, err
+	}
+
+	params := make([]*Value, 0, len(expr.testArgs))
+	for _, arg := range expr.testArgs {
+		param, err := arg.Evaluate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		params = append(params, param)
+	}
+
+	result, err := expr.test.fn(v, params)
+	if err != nil {
+		return nil, err.updateFromTokenIfNeeded(ctx.template, expr.opToken)
+	}
+	return AsValue(result != expr.testNegate), nil
+}
+
 func (expr *simpleExpression) Evaluate(ctx *ExecutionContext) (*Value, *Error) {
 	t1, err := expr.term1.Evaluate(ctx)
 	if err != nil {
@@ -318,6 +497,68 @@ func (p *Parser) parseFactor() (IEvaluator, *Error) {
 	return p.parseVariableOrLiteralWithFilter()
 }
 
+// '[' [expr {',' expr} [',']] ']'
+func (p *Parser) parseListLiteral() (IEvaluator, *Error) {
+	list := &listLiteral{
+		locationToken: p.Current(),
+	}
+	p.Consume() // consume '['
+
+	for p.Match(TokenSymbol, "]") == nil {
+		if p.Remaining() == 0 {
+			return nil, p.Error("Unexpected EOF, expected ']' to close the list.", p.lastToken)
+		}
+
+		item, err := p.ParseExpression()
+		if err != nil {
+			return nil, err
+		}
+		list.items = append(list.items, item)
+
+		// A comma is required between items (a trailing one is allowed)
+		if p.Match(TokenSymbol, ",") == nil && p.Peek(TokenSymbol, "]") == nil {
+			return nil, p.Error("Expected ',' or ']' after list item.", nil)
+		}
+	}
+
+	return list, nil
+}
+
+// '{' [expr ':' expr {',' expr ':' expr} [',']] '}'
+func (p *Parser) parseMapLiteral() (IEvaluator, *Error) {
+	m := &mapLiteral{
+		locationToken: p.Current(),
+	}
+	p.Consume() // consume '{'
+
+	for p.Match(TokenSymbol, "}") == nil {
+		if p.Remaining() == 0 {
+			return nil, p.Error("Unexpected EOF, expected '}' to close the map.", p.lastToken)
+		}
+
+		key, err := p.ParseExpression()
+		if err != nil {
+			return nil, err
+		}
+		if p.Match(TokenSymbol, ":") == nil {
+			return nil, p.Error("Expected ':' after map key.", nil)
+		}
+		value, err := p.ParseExpression()
+		if err != nil {
+			return nil, err
+		}
+		m.keys = append(m.keys, key)
+		m.values = append(m.values, value)
+
+		// A comma is required between items (a trailing one is allowed)
+		if p.Match(TokenSymbol, ",") == nil && p.Peek(TokenSymbol, "}") == nil {
+			return nil, p.Error("Expected ',' or '}' after map item.", nil)
+		}
+	}
+
+	return m, nil
+}
+
 func (p *Parser) parsePower() (IEvaluator, *Error) {
 	pw := new(power)
 
@@ -451,6 +692,21 @@ func (p *Parser) parseRelationalExpression() (IEvaluator, *Error) {
 		}
 		expr.opToken = t
 		expr.expr2 = expr2
+	} else if t := p.Match(TokenIdentifier, "is"); t != nil {
+		// 'is' binds tighter than a leading 'not' (like in Jinja):
+		// not x is defined means not (x is defined)
+		negated := false
+		if s, ok := expr1.(*simpleExpression); ok && s.negate && !s.negativeSign && s.term2 == nil {
+			expr.expr1 = s.term1
+			negated = true
+		}
+
+		expr.opToken = t
+		if err := p.parseTest(expr); err != nil {
+			return nil, err
+		}
+		expr.testNegate = expr.testNegate != negated
+		return expr, nil
 	}
 
 	if expr.expr2 == nil {
@@ -461,7 +717,108 @@ func (p *Parser) parseRelationalExpression() (IEvaluator, *Error) {
 	return expr, nil
 }
 
+// 'is' ['not'] test ['(' [expr {',' expr}] ')' | variable | literal]
+func (p *Parser) parseTest(expr *relationalExpression) *Error {
+	if p.Match(TokenKeyword, "not") != nil {
+		expr.testNegate = true
+	}
+
+	// The in-test is named like the in-keyword
+	nameToken := p.MatchOne(TokenKeyword, "in")
+	if nameToken == nil {
+		nameToken = p.MatchType(TokenIdentifier)
+	}
+	if nameToken == nil {
+		return p.Error("Expected a test name (identifier) after 'is'.", nil)
+	}
+
+	t, exists := p.template.set.getTest(nameToken.Val)
+	if !exists {
+		return p.Error(fmt.Sprintf("Test '%s' does not exist.", nameToken.Val), nameToken)
+	}
+	if _, isBanned := p.template.set.bannedTests[nameToken.Val]; isBanned {
+		return p.Error(fmt.Sprintf("Usage of test '%s' is not allowed (sandbox restriction active).", nameToken.Val), nameToken)
+	}
+	expr.test = t
+
+	if p.Match(TokenSymbol, "(") != nil {
+		for p.Match(TokenSymbol, ")") == nil {
+			arg, err := p.ParseExpression()
+			if err != nil {
+				return err
+			}
+			expr.testArgs = append(expr.testArgs, arg)
+
+			if p.Match(TokenSymbol, ")") != nil {
+				break
+			}
+			if p.Match(TokenSymbol, ",") == nil {
+				return p.Error("Expected ',' or ')' after test argument.", nil)
+			}
+		}
+	} else if p.peekTestArgument() {
+		// A single argument doesn't need parentheses: x is divisibleby 3
+		arg, err := p.parseVariableOrLiteral()
+		if err != nil {
+			return err
+		}
+		expr.testArgs = append(expr.testArgs, arg)
+	}
+
+	return nil
+}
+
+// peekTestArgument reports whether the current token starts the argument of
+// a test without parentheses (n is in items, n is in [1, 2]). Identifiers continuing the
+// surrounding expression or tag (like the else of a conditional expression
+// or the name of a with-pair) don't.
+func (p *Parser) peekTestArgument() bool {
+	switch {
+	case p.PeekType(TokenNumber) != nil, p.PeekType(TokenString) != nil,
+		p.PeekOne(TokenKeyword, "true", "false") != nil,
+		p.PeekOne(TokenSymbol, "[", "{") != nil:
+		return true
+	case p.PeekType(TokenIdentifier) != nil:
+		return p.PeekOne(TokenIdentifier, "if", "else", "with", "only") == nil &&
+			p.PeekN(1, TokenSymbol, "=") == nil
+	}
+	return false
+}
+
 func (p *Parser) ParseExpression() (IEvaluator, *Error) {
+	expr1, err := p.parseLogicalExpression()
+	if err != nil {
+		return nil, err
+	}
+
+	// Conditional expression: expr1 if condition else expr2
+	start := p.idx
+	if p.Match(TokenIdentifier, "if") != nil {
+		condition, err := p.parseLogicalExpression()
+		if err != nil {
+			return nil, err
+		}
+		if p.Match(TokenIdentifier, "else") == nil {
+			// No conditional expression (the 'if' might belong to the tag,
+			// like in {% for x in items if x %}); leave it to the caller
+			p.idx = start
+			return expr1, nil
+		}
+		expr2, err := p.ParseExpression()
+		if err != nil {
+			return nil, err
+		}
+		return &conditionalExpression{
+			condition: condition,
+			expr1:     expr1,
+			expr2:     expr2,
+		}, nil
+	}
+
+	return expr1, nil
+}
+
+func (p *Parser) parseLogicalExpression() (IEvaluator, *Error) {
 	rexpr1, err := p.parseRelationalExpression()
 	if err != nil {
 		return nil, err
@@ -474,7 +831,7 @@ func (p *Parser) ParseExpression() (IEvaluator, *Error) {
 	if p.PeekOne(TokenSymbol, "&&", "||") != nil || p.PeekOne(TokenKeyword, "and", "or") != nil {
 		op := p.Current()
 		p.Consume()
-		expr2, err := p.ParseExpression()
+		expr2, err := p.parseLogicalExpression()
 		if err != nil {
 			return nil, err
 		}
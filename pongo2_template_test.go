@@ -2,12 +2,18 @@ package pongo2_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"testing"
+	"testing/fstest"
 	"time"
 
 	"github.com/flosch/pongo2"
@@ -399,6 +405,1415 @@ func TestBaseDirectory(t *testing.T) {
 	}
 }
 
+func TestFSLoader(t *testing.T) {
+	fsys := fstest.MapFS{
+		"base.html":         {Data: []byte("{% block content %}base{% endblock %}")},
+		"pages/index.html":  {Data: []byte(`{% extends "/base.html" %}{% block content %}{% include "part.html" %}|{% ssi "part.html" %}|{% import "macros.html" greet %}{{ greet("fs") }}{% endblock %}`)},
+		"pages/part.html":   {Data: []byte("part {{ name }}")},
+		"pages/macros.html": {Data: []byte("{% macro greet(who) export %}hello {{ who }}{% endmacro %}")},
+	}
+
+	s := pongo2.NewSet("test set with fs loader", pongo2.NewFSLoader(fsys))
+	tpl, err := s.FromCache("pages/index.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(pongo2.Context{"name": "pongo2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustStr := "part pongo2|part {{ name }}|hello fs"
+	if out != mustStr {
+		t.Errorf("out ('%s') != mustStr ('%s')", out, mustStr)
+	}
+
+	if _, err := s.FromFile("pages/doesnotexist.html"); err == nil {
+		t.Error("expected an error for a non-existing template")
+	}
+}
+
+func TestMemoryLoader(t *testing.T) {
+	loader := pongo2.NewMemoryLoader(map[string]string{
+		"base.html":        "{% block content %}{% endblock %}{% include \"footer.html\" if_exists %}",
+		"pages/index.html": `{% extends "/base.html" %}{% block content %}{% include "part.html" %}{% endblock %}`,
+		"pages/part.html":  "part {{ name }}",
+	})
+	s := pongo2.NewSet("test set with memory loader", loader)
+
+	render := func() string {
+		tpl, err := s.FromCache("pages/index.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tpl.Execute(pongo2.Context{"name": "pongo2"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	if out := render(); out != "part pongo2" {
+		t.Errorf("out ('%s') != 'part pongo2'", out)
+	}
+
+	// Changing a dependency must evict the cached template
+	loader.Set("pages/part.html", "new part {{ name }}")
+	if out := render(); out != "new part pongo2" {
+		t.Errorf("out ('%s') != 'new part pongo2'", out)
+	}
+
+	// Same goes for templates which did not exist before
+	loader.Set("footer.html", "|footer")
+	if out := render(); out != "new part pongo2|footer" {
+		t.Errorf("out ('%s') != 'new part pongo2|footer'", out)
+	}
+
+	loader.Delete("pages/part.html")
+	if _, err := s.FromCache("pages/index.html"); err == nil {
+		t.Error("expected an error after deleting an included template")
+	}
+
+	// Closed sets are not notified (and referenced) by the loader anymore
+	loader.Set("pages/part.html", "part {{ name }}")
+	if out := render(); out != "part pongo2|footer" {
+		t.Errorf("out ('%s') != 'part pongo2|footer'", out)
+	}
+	s.Close()
+	s.Close()
+	loader.Set("pages/part.html", "closed part {{ name }}")
+	if out := render(); out != "part pongo2|footer" {
+		t.Errorf("out ('%s') != 'part pongo2|footer'", out)
+	}
+}
+
+func TestAutoReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pongo2_autoreload_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	write := func(name, content string, modified time.Time) {
+		fn := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(fn, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(fn, modified, modified); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("base.html", "{% block content %}{% endblock %} v1", time1)
+	write("index.html", `{% extends "base.html" %}{% block content %}index{% endblock %}`, time1)
+
+	s := pongo2.NewSet("test set with auto reload", pongo2.MustNewLocalFileSystemLoader(dir))
+	s.AutoReload = true
+
+	render := func() string {
+		tpl, err := s.FromCache("index.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tpl.Execute(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	if out := render(); out != "index v1" {
+		t.Errorf("out ('%s') != 'index v1'", out)
+	}
+
+	// Change the parent template
+	write("base.html", "{% block content %}{% endblock %} v2", time1.Add(time.Second))
+	if out := render(); out != "index v2" {
+		t.Errorf("out ('%s') != 'index v2'", out)
+	}
+
+	// Changes are not detected before the interval passed
+	s.AutoReloadInterval = time.Hour
+	render()
+	write("base.html", "{% block content %}{% endblock %} v3", time1.Add(2*time.Second))
+	if out := render(); out != "index v2" {
+		t.Errorf("out ('%s') != 'index v2'", out)
+	}
+}
+
+// changingLoader changes a template of the wrapped loader right after it has
+// been read (it's not observable, so sets don't get notified about it).
+type changingLoader struct {
+	loader *pongo2.MemoryLoader
+	change func()
+}
+
+func (l *changingLoader) Get(path string) (io.Reader, error) {
+	r, err := l.loader.Get(path)
+	if l.change != nil {
+		l.change()
+		l.change = nil
+	}
+	return r, err
+}
+
+func (l *changingLoader) Stat(path string) (time.Time, error) {
+	return l.loader.Stat(path)
+}
+
+func (l *changingLoader) Abs(base, name string) string {
+	return l.loader.Abs(base, name)
+}
+
+func TestAutoReloadChangeDuringCompilation(t *testing.T) {
+	memory := pongo2.NewMemoryLoader(map[string]string{"index.html": "v1"})
+	loader := &changingLoader{loader: memory}
+	s := pongo2.NewSet("test set with changing templates", loader)
+	s.AutoReload = true
+
+	// The template changes after it has been read, but before it's cached
+	time.Sleep(time.Millisecond)
+	loader.change = func() { memory.Set("index.html", "v2") }
+	for _, expected := range []string{"v1", "v2"} {
+		tpl, err := s.FromCache("index.html")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tpl.Execute(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != expected {
+			t.Errorf("out ('%s') != '%s'", out, expected)
+		}
+	}
+}
+
+// blockingLoader blocks reading (or stat'ing) a template until it's released.
+type blockingLoader struct {
+	loader      *pongo2.MemoryLoader
+	getBlocked  string
+	statBlocked string
+	entered     chan bool
+	release     chan bool
+}
+
+func (l *blockingLoader) Get(path string) (io.Reader, error) {
+	if path == l.getBlocked {
+		l.entered <- true
+		<-l.release
+	}
+	return l.loader.Get(path)
+}
+
+func (l *blockingLoader) Stat(path string) (time.Time, error) {
+	if path == l.statBlocked {
+		l.entered <- true
+		<-l.release
+	}
+	return l.loader.Stat(path)
+}
+
+func (l *blockingLoader) Abs(base, name string) string {
+	return l.loader.Abs(base, name)
+}
+
+func TestTemplateCacheConcurrentLoads(t *testing.T) {
+	loader := &blockingLoader{
+		loader: pongo2.NewMemoryLoader(map[string]string{
+			"a.html": "a",
+			"b.html": "b",
+			"c.html": "c",
+		}),
+		entered: make(chan bool),
+	}
+	s := pongo2.NewSet("test set with blocking loader", loader)
+	s.AutoReload = true
+
+	fromCache := func(name string, done chan bool) {
+		if _, err := s.FromCache(name); err != nil {
+			t.Error(err)
+		}
+		done <- true
+	}
+	waitFor := func(done chan bool, what string) {
+		select {
+		case <-done:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timeout waiting for %s", what)
+		}
+	}
+
+	for _, name := range []string{"a.html", "b.html"} {
+		if _, err := s.FromCache(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Checking a template for changes must not block other templates
+	loader.statBlocked = "a.html"
+	loader.release = make(chan bool)
+	doneA, doneB := make(chan bool, 1), make(chan bool, 1)
+	go fromCache("a.html", doneA)
+	<-loader.entered
+	go fromCache("b.html", doneB)
+	waitFor(doneB, "b.html while a.html is being checked")
+	close(loader.release)
+	waitFor(doneA, "a.html")
+
+	// Callers waiting for a concurrent compilation are counted separately
+	before := s.CacheStats()
+	loader.getBlocked = "c.html"
+	loader.release = make(chan bool)
+	done := make(chan bool, 2)
+	go fromCache("c.html", done)
+	<-loader.entered
+	go fromCache("c.html", done)
+	for deadline := time.Now().Add(5 * time.Second); s.CacheStats().Waits == before.Waits; {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the second caller to join the compilation")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(loader.release)
+	waitFor(done, "c.html")
+	waitFor(done, "c.html")
+
+	stats := s.CacheStats()
+	if stats.Hits != before.Hits || stats.Misses != before.Misses+1 || stats.Waits != before.Waits+1 {
+		t.Errorf("unexpected cache stats: %+v (before: %+v)", stats, before)
+	}
+}
+
+func TestTemplateCache(t *testing.T) {
+	loader := pongo2.NewMemoryLoader(map[string]string{
+		"a.html":    "a",
+		"b.html":    "b",
+		"c.html":    "c",
+		"lazy.html": "{% include name %}",
+	})
+	s := pongo2.NewSet("test set with cache", loader)
+	s.CacheSize = 2
+
+	for _, name := range []string{"a.html", "b.html", "a.html", "c.html"} {
+		if _, err := s.FromCache(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if cached := fmt.Sprint(s.CachedTemplates()); cached != "[c.html a.html]" {
+		t.Errorf("cached templates: %s", cached)
+	}
+	stats := s.CacheStats()
+	if stats.Size != 2 || stats.Hits != 1 || stats.Misses != 3 || stats.Evictions != 1 {
+		t.Errorf("unexpected cache stats: %+v", stats)
+	}
+
+	s.Invalidate("a.html")
+	if cached := fmt.Sprint(s.CachedTemplates()); cached != "[c.html]" {
+		t.Errorf("cached templates after Invalidate: %s", cached)
+	}
+	s.InvalidateAll()
+	if cached := s.CachedTemplates(); len(cached) != 0 {
+		t.Errorf("cached templates after InvalidateAll: %v", cached)
+	}
+
+	// Lazy includes are served from the cache
+	s.CacheSize = 0
+	tpl, err := s.FromCache("lazy.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func() {
+			defer func() { done <- true }()
+			out, err := tpl.Execute(pongo2.Context{"name": "b.html"})
+			if err != nil {
+				t.Error(err)
+			}
+			if out != "b" {
+				t.Errorf("out ('%s') != 'b'", out)
+			}
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+	if cached := fmt.Sprint(s.CachedTemplates()); cached != "[b.html lazy.html]" {
+		t.Errorf("cached templates after lazy include: %s", cached)
+	}
+}
+
+// countingLoader counts the templates read from the wrapped loader.
+type countingLoader struct {
+	*pongo2.FSLoader
+
+	mu   sync.Mutex
+	gets map[string]int
+}
+
+func (l *countingLoader) Get(path string) (io.Reader, error) {
+	l.mu.Lock()
+	l.gets[path]++
+	l.mu.Unlock()
+	return l.FSLoader.Get(path)
+}
+
+func TestChainedLoader(t *testing.T) {
+	tenant := &countingLoader{
+		FSLoader: pongo2.NewFSLoader(fstest.MapFS{
+			"index.html": {Data: []byte(`{% extends "index.html" %}{% block title %}tenant/{{ block.Super }}{% endblock %}`)},
+		}),
+		gets: make(map[string]int),
+	}
+	theme := fstest.MapFS{
+		"index.html":  {Data: []byte(`{% extends "index.html" %}{% block title %}theme/{{ block.Super }}{% endblock %}`)},
+		"footer.html": {Data: []byte("theme footer")},
+		"notes#1":     {Data: []byte("theme notes")},
+	}
+	defaults := fstest.MapFS{
+		"index.html":  {Data: []byte(`{% extends "base.html" %}{% block title %}default{% endblock %}`)},
+		"base.html":   {Data: []byte(`{% block title %}{% endblock %}|{% include "footer.html" %}`)},
+		"footer.html": {Data: []byte("default footer")},
+	}
+
+	s := pongo2.NewSet("test set with chained loader", pongo2.NewChainedLoader(
+		tenant,
+		pongo2.NewFSLoader(theme),
+		pongo2.NewFSLoader(defaults),
+	))
+	out, err := s.RenderTemplateFile("index.html", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mustStr := "tenant/theme/default|theme footer"
+	if out != mustStr {
+		t.Errorf("out ('%s') != mustStr ('%s')", out, mustStr)
+	}
+	// Looking up the loader providing a template must not read it
+	if gets := tenant.gets["index.html"]; gets != 1 {
+		t.Errorf("expected index.html to be read once from the tenant loader, got %d reads", gets)
+	}
+
+	// Template names might look like the internal loader index
+	out, err = s.RenderTemplateFile("notes#1", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "theme notes" {
+		t.Errorf("out ('%s') != 'theme notes'", out)
+	}
+
+	if _, err := s.FromFile("doesnotexist.html"); err == nil {
+		t.Error("expected an error for a non-existing template")
+	}
+
+	// The loader index isn't part of the names shown to users
+	s = pongo2.NewSet("test set with broken defaults", pongo2.NewChainedLoader(
+		pongo2.NewFSLoader(fstest.MapFS{
+			"page.html": {Data: []byte(`{% extends "page.html" %}`)},
+			"dyn.html":  {Data: []byte(`{% extends self %}`)},
+		}),
+		pongo2.NewFSLoader(fstest.MapFS{
+			"page.html": {Data: []byte("{{ 1|doesnotexist }}")},
+			"dyn.html":  {Data: []byte("default dyn")},
+		}),
+	))
+	_, err = s.FromFile("page.html")
+	perr, ok := err.(*pongo2.Error)
+	if !ok || perr.Filename != "page.html" || strings.Contains(err.Error(), "\x00") {
+		t.Errorf("expected an error in page.html, got %q", err)
+	} else if line, _, _ := perr.RawLine(); line != "{{ 1|doesnotexist }}" {
+		t.Errorf("expected the line of the default page.html, got %q", line)
+	}
+	out, err = pongo2.Must(s.FromCache("dyn.html")).Execute(pongo2.Context{"self": "dyn.html"})
+	if err != nil || out != "default dyn" {
+		t.Fatalf("expected 'default dyn', got '%s' (err = %v)", out, err)
+	}
+	if names := s.CachedTemplates(); len(names) != 2 || names[0] != "dyn.html" || names[1] != "dyn.html" {
+		t.Errorf("expected both dyn.html templates to be cached, got %q", names)
+	}
+}
+
+func TestSandboxedFilesystemLoader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pongo2_sandbox_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"templates/index.html":   "{{ name }}",
+		"templates/partial.html": `{% include "index.html" %}`,
+		"secret/secret.html":     "secret",
+	}
+	for name, content := range files {
+		fn := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(fn), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(dir, "secret", "secret.html"), filepath.Join(dir, "templates", "link.html")); err != nil {
+		t.Fatal(err)
+	}
+
+	loader, err := pongo2.NewSandboxedFilesystemLoader(filepath.Join(dir, "templates"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := pongo2.NewSet("sandboxed test set", loader)
+
+	out, err := s.RenderTemplateFile("partial.html", pongo2.Context{"name": "pongo2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "pongo2" {
+		t.Errorf("out ('%s') != 'pongo2'", out)
+	}
+
+	tests := []string{
+		`{% include "../secret/secret.html" %}`,
+		`{% include "../secret/secret.html" if_exists %}`,
+		`{% include "link.html" %}`,
+		`{% extends "../secret/secret.html" %}`,
+		`{% import "../secret/secret.html" mymacro %}`,
+		`{% ssi "../secret/secret.html" %}`,
+		`{% ssi "link.html" parsed %}`,
+	}
+	for _, test := range tests {
+		tpl, err := s.FromString(test)
+		if err == nil {
+			_, err = tpl.Execute(nil)
+		}
+		if err == nil {
+			t.Errorf("%s: expected a sandbox error, got none", test)
+			continue
+		}
+		if _, ok := err.(*pongo2.Error).OrigError.(*pongo2.SandboxError); !ok {
+			t.Errorf("%s: expected a sandbox error, got: %s", test, err)
+		}
+	}
+
+	// Lazy includes are checked during execution
+	tpl, err := s.FromString("{% include filename %}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tpl.Execute(pongo2.Context{"filename": filepath.Join(dir, "secret", "secret.html")})
+	if err == nil {
+		t.Fatal("expected a sandbox error for a lazy include, got none")
+	}
+	if _, ok := err.(*pongo2.Error).OrigError.(*pongo2.SandboxError); !ok {
+		t.Errorf("expected a sandbox error for a lazy include, got: %s", err)
+	}
+}
+
+func TestSandboxedFilesystemLoaderSymlinkedBaseDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "pongo2_sandbox_")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err := os.Mkdir(filepath.Join(dir, "real"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "real", "a.html"), []byte("{{ name }}"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "secret.html"), []byte("secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "real"), filepath.Join(dir, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	loader, err := pongo2.NewSandboxedFilesystemLoader(filepath.Join(dir, "link"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := pongo2.NewSet("sandboxed symlink test set", loader)
+
+	out, err := s.RenderTemplateFile("a.html", pongo2.Context{"name": "pongo2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "pongo2" {
+		t.Errorf("out ('%s') != 'pongo2'", out)
+	}
+
+	_, err = s.FromFile("../secret.html")
+	if err == nil {
+		t.Fatal("expected a sandbox error, got none")
+	}
+	if _, ok := err.(*pongo2.Error).OrigError.(*pongo2.SandboxError); !ok {
+		t.Errorf("expected a sandbox error, got: %s", err)
+	}
+
+	// The symlinks within the static prefix of glob patterns are resolved as well
+	loader, err = pongo2.NewSandboxedFilesystemLoader(filepath.Join(dir, "link"), filepath.Join(dir, "link", "*.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s = pongo2.NewSet("sandboxed symlink glob test set", loader)
+	if out, err := s.RenderTemplateFile("a.html", pongo2.Context{"name": "glob"}); err != nil || out != "glob" {
+		t.Errorf("expected 'glob', got '%s' (err = %v)", out, err)
+	}
+	if _, err := s.FromFile("../secret.html"); err == nil {
+		t.Error("expected a sandbox error for a file outside of the glob pattern, got none")
+	}
+}
+
+func TestSetRegistries(t *testing.T) {
+	urlFilter := func(prefix string) pongo2.FilterFunction {
+		return func(in *pongo2.Value, param *pongo2.Value) (*pongo2.Value, *pongo2.Error) {
+			return pongo2.AsValue(prefix + in.String()), nil
+		}
+	}
+
+	webSet := pongo2.NewSet("web", pongo2.MustNewLocalFileSystemLoader(""))
+	mailSet := pongo2.NewSet("mail", pongo2.MustNewLocalFileSystemLoader(""))
+	if err := webSet.RegisterFilter("url", urlFilter("/")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mailSet.RegisterFilter("url", urlFilter("https://example.com/")); err != nil {
+		t.Fatal(err)
+	}
+	if err := mailSet.ReplaceFilter("upper", urlFilter("replaced:")); err != nil {
+		t.Fatal(err)
+	}
+	escapeSet := pongo2.NewSet("escape", pongo2.MustNewLocalFileSystemLoader(""))
+	if err := escapeSet.ReplaceFilter("escape", urlFilter("escaped:")); err != nil {
+		t.Fatal(err)
+	}
+	if err := webSet.RegisterTag("hello", tagSandboxDemoTagParser); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := webSet.RegisterFilter("url", urlFilter("")); err == nil {
+		t.Error("expected an error when registering a filter twice")
+	}
+	if err := webSet.RegisterFilter("lower", urlFilter("")); err == nil {
+		t.Error("expected an error when registering a filter which exists globally")
+	}
+	if err := webSet.ReplaceTag("doesnotexist", tagSandboxDemoTagParser); err == nil {
+		t.Error("expected an error when replacing a non-existing tag")
+	}
+
+	tests := []struct {
+		set  *pongo2.TemplateSet
+		tpl  string
+		want string
+	}{
+		{webSet, `{{ "about"|url }}|{{ "x"|upper }}|{% hello %}`, "/about|X|hello"},
+		{mailSet, `{{ "about"|url }}|{{ "x"|upper }}`, "https://example.com/about|replaced:x"},
+		{webSet, `{{ "<b>" }}|{% firstof "<i>" %}`, "&lt;b&gt;|&lt;i&gt;"},
+		{escapeSet, `{{ "<b>" }}|{% firstof "<i>" %}`, "escaped:<b>|escaped:<i>"},
+	}
+	for _, test := range tests {
+		out, err := test.set.RenderTemplateString(test.tpl, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != test.want {
+			t.Errorf("out ('%s') != want ('%s')", out, test.want)
+		}
+	}
+
+	if _, err := mailSet.FromString("{% hello %}"); err == nil {
+		t.Error("expected an error when using a tag of another set")
+	}
+	if _, err := pongo2.FromString(`{{ "about"|url }}`); err == nil {
+		t.Error("expected an error when using a filter of another set")
+	}
+
+	// Registration is safe for concurrent use
+	done := make(chan bool)
+	for i := 0; i < 10; i++ {
+		go func(i int) {
+			defer func() { done <- true }()
+			webSet.RegisterFilter(fmt.Sprintf("concurrent_%d", i), urlFilter(""))
+			if _, err := webSet.FromString(`{{ "x"|url }}`); err != nil {
+				t.Error(err)
+			}
+		}(i)
+	}
+	for i := 0; i < 10; i++ {
+		<-done
+	}
+}
+
+func TestExecuteContext(t *testing.T) {
+	tpl, err := pongo2.FromString("{% for i in items %}{{ tick() }}{% endfor %}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ticks := 0
+	data := pongo2.Context{
+		"items": make([]int, 1000),
+		"tick": func(ectx *pongo2.ExecutionContext) string {
+			if ectx.Context() != ctx {
+				t.Error("ExecutionContext.Context() does not return the execution's context.Context")
+			}
+			ticks++
+			if ticks == 10 {
+				cancel()
+			}
+			return "."
+		},
+	}
+
+	_, err = tpl.ExecuteContext(ctx, data)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got: %v", err)
+	}
+	if ticks != 10 {
+		t.Errorf("expected the execution to stop after 10 iterations, got %d", ticks)
+	}
+
+	// Deadlines are respected as well, even within includes
+	tpl, err = pongo2.FromString(`{% include "template_tests/includes.helper" %}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	deadlineCtx, cancelDeadline := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelDeadline()
+	var buf bytes.Buffer
+	err = tpl.ExecuteWriterContext(deadlineCtx, nil, &buf)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got: %v", err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("expected no output, got '%s'", buf.String())
+	}
+}
+
+func TestLimits(t *testing.T) {
+	loader := pongo2.NewMemoryLoader(map[string]string{
+		"output.tpl":     "{% for i in items %}0123456789{% endfor %}",
+		"loop.tpl":       "{% for i in items %}{% for j in items %}{% endfor %}{% endfor %}",
+		"lorem.tpl":      "{% lorem 1000000 w %}",
+		"lorem_loop.tpl": "{% for i in items %}{% set text %}{% lorem 20 w %}{% endset %}{% endfor %}",
+		"cycle.tpl":      "{% for i in items %}{% cycle 'a' 'b' %}{% endfor %}",
+		"filter.tpl":     "{% for i in items if i %}{% endfor %}",
+		"self.tpl":       `{% include "self.tpl" %}`,
+		"lazyself.tpl":   `{% include name %}`,
+		"recursive.tpl":  "{% macro r(n) %}{{ r(n) }}{% endmacro %}{{ r(1) }}",
+	})
+	set := pongo2.NewSet("limits", loader)
+	set.MaxOutputBytes = 1000
+	set.MaxLoopIterations = 1000
+	set.MaxNodes = 500
+	set.MaxRecursionDepth = 50
+
+	tests := []struct {
+		name  string
+		limit string
+		data  pongo2.Context
+	}{
+		{"output.tpl", "output bytes", pongo2.Context{"items": make([]int, 200)}},
+		{"loop.tpl", "loop iterations", pongo2.Context{"items": make([]int, 100)}},
+		{"lorem.tpl", "loop iterations", nil},
+		{"lorem_loop.tpl", "loop iterations", pongo2.Context{"items": make([]int, 60)}},
+		{"cycle.tpl", "nodes", pongo2.Context{"items": make([]int, 900)}},
+		{"filter.tpl", "loop iterations", pongo2.Context{"items": make([]int, 1100)}},
+		{"lazyself.tpl", "recursion depth", pongo2.Context{"name": "lazyself.tpl"}},
+		{"recursive.tpl", "recursion depth", nil},
+	}
+	for _, test := range tests {
+		tpl, err := set.FromFile(test.name)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		var buf bytes.Buffer
+		err = tpl.ExecuteWriter(test.data, &buf)
+		var limitErr *pongo2.LimitError
+		if !errors.As(err, &limitErr) {
+			t.Errorf("%s: expected a *LimitError, got: %v", test.name, err)
+			continue
+		}
+		if limitErr.Limit != test.limit {
+			t.Errorf("%s: expected the %s limit to be exceeded, got: %v", test.name, test.limit, err)
+		}
+		if perr := err.(*pongo2.Error); perr.Line <= 0 {
+			t.Errorf("%s: expected the error to carry the template position, got: %v", test.name, err)
+		}
+		if buf.Len() > 0 {
+			t.Errorf("%s: expected no output, got %d bytes", test.name, buf.Len())
+		}
+	}
+
+	// Output written before the limit has been exceeded is at most MaxOutputBytes
+	tpl := pongo2.Must(set.FromFile("output.tpl"))
+	var buf bytes.Buffer
+	err := tpl.ExecuteWriterUnbuffered(pongo2.Context{"items": make([]int, 200)}, &buf)
+	if err == nil || buf.Len() != set.MaxOutputBytes {
+		t.Errorf("expected %d bytes and an error, got %d bytes (err = %v)", set.MaxOutputBytes, buf.Len(), err)
+	}
+
+	// Within the limits everything works as usual
+	out, err := tpl.Execute(pongo2.Context{"items": make([]int, 10)})
+	if err != nil || len(out) != 100 {
+		t.Errorf("expected 100 bytes of output, got %d bytes (err = %v)", len(out), err)
+	}
+
+	// Self-including templates are detected while compiling
+	_, err = set.FromFile("self.tpl")
+	if err == nil || !strings.Contains(err.Error(), "recursively includes itself") {
+		t.Errorf("expected a recursive include error, got: %v", err)
+	}
+}
+
+func TestUndefined(t *testing.T) {
+	type user struct {
+		Name    string
+		Friends []string
+	}
+	data := pongo2.Context{
+		"usr":    &user{Name: "flosch", Friends: []string{"a"}},
+		"nilptr": (*user)(nil),
+		"none":   nil,
+	}
+	set := pongo2.NewSet("undefined", pongo2.NewMemoryLoader(nil))
+	render := func(s string) (string, error) {
+		tpl, err := set.FromString(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tpl.Execute(data)
+	}
+
+	// Silent (default)
+	if out, err := render("[{{ usr.nmae }}{{ missing }}]"); err != nil || out != "[]" {
+		t.Errorf("silent: got '%s' (err = %v)", out, err)
+	}
+
+	// Debug
+	set.Undefined = pongo2.UndefinedDebug
+	if out, err := render("[{{ usr.nmae }}|{{ usr.Friends.3 }}|{{ none }}]"); err != nil || out != "[{{ usr.nmae }}|{{ usr.Friends.3 }}|]" {
+		t.Errorf("debug: got '%s' (err = %v)", out, err)
+	}
+	// Filters see nil; the marker is only rendered by variable tags
+	if out, err := render(`[{{ usr.nmae|upper|slice:":4" }}|{% set n = usr.nmae|length %}{{ n }}|{{ usr.nmae|default:"-" }}]`); err != nil || out != "[{{ usr.nmae }}|0|-]" {
+		t.Errorf("debug with filters: got '%s' (err = %v)", out, err)
+	}
+
+	// Strict
+	set.Undefined = pongo2.UndefinedStrict
+	tests := []struct {
+		tpl      string
+		variable string
+		segment  string
+		col      int
+	}{
+		{"{{ missing }}", "missing", "missing", 4},
+		{"{{ usr.nmae }}", "usr.nmae", "nmae", 8},
+		{"{{ usr.Friends.3 }}", "usr.Friends.3", "3", 16},
+		{"{{ nilptr.Name }}", "nilptr.Name", "Name", 11},
+		{"{{ usr.nmae|upper }}", "usr.nmae", "nmae", 8},
+		{"{% if missing.attr == 1 %}{% endif %}", "missing", "missing", 7},
+		{`{% if usr.nam == "x" %}{% endif %}`, "usr.nam", "nam", 11},
+		{"{% if usr and usr.nmae + 1 %}{% endif %}", "usr.nmae", "nmae", 19},
+		{"{% if not usr.Friends.3|upper %}{% endif %}", "usr.Friends.3", "3", 23},
+		{"{% firstof missing.attr > 0 %}", "missing", "missing", 12},
+	}
+	for _, test := range tests {
+		_, err := render(test.tpl)
+		var undefErr *pongo2.UndefinedError
+		if !errors.As(err, &undefErr) {
+			t.Errorf("%s: expected an *UndefinedError, got: %v", test.tpl, err)
+			continue
+		}
+		if undefErr.Variable != test.variable || undefErr.Segment != test.segment {
+			t.Errorf("%s: expected '%s' (segment '%s'), got: %v", test.tpl, test.variable, test.segment, err)
+		}
+		if perr := err.(*pongo2.Error); perr.Line != 1 || perr.Column != test.col {
+			t.Errorf("%s: expected position 1:%d, got: %v", test.tpl, test.col, err)
+		}
+	}
+
+	// Defined nil values, defaults and tests keep working in strict mode
+	out, err := render(`{{ none }}{{ usr.nmae|default:"x" }}{{ missing|default_if_none:"y" }}` +
+		`{% if missing.foo %}no{% else %}z{% endif %}{% firstof missing usr.Name %}` +
+		`{% if not missing or (usr.nmae and missing.foo) %}!{% endif %}{% if missing is defined %}no{% endif %}`)
+	if err != nil || out != "xyzflosch!" {
+		t.Errorf("strict: got '%s' (err = %v)", out, err)
+	}
+
+	// UndefinedHandler
+	set.UndefinedHandler = func(ctx *pongo2.ExecutionContext, variable, segment string) (*pongo2.Value, error) {
+		if segment == "fail" {
+			return nil, errors.New("handler failed")
+		}
+		return pongo2.AsValue("<" + variable + ">"), nil
+	}
+	if out, err := render("{{ usr.nmae }}"); err != nil || out != "&lt;usr.nmae&gt;" {
+		t.Errorf("handler: got '%s' (err = %v)", out, err)
+	}
+	if _, err := render("{{ usr.fail }}"); err == nil || !strings.Contains(err.Error(), "handler failed") {
+		t.Errorf("handler: expected an error, got: %v", err)
+	}
+}
+
+func TestWhitespaceControl(t *testing.T) {
+	loader := pongo2.NewMemoryLoader(map[string]string{
+		"blocks.tpl": "<ul>\n    {% for i in items %}\n    <li>{{ i }}</li>\n    {% endfor %}\n</ul>\n",
+		"error.tpl":  "a\n\n  {%- if true -%}\n\n\n  {{ fail( }}{% endif %}",
+	})
+	set := pongo2.NewSet("whitespace", loader)
+	set.TrimBlocks = true
+	set.LstripBlocks = true
+
+	tpl, err := set.FromFile("blocks.tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(pongo2.Context{"items": []int{1, 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "<ul>\n    <li>1</li>\n    <li>2</li>\n</ul>\n"
+	if out != expected {
+		t.Errorf("expected %q, got %q", expected, out)
+	}
+
+	// Positions are kept after stripping
+	_, err = set.FromFile("error.tpl")
+	perr, ok := err.(*pongo2.Error)
+	if !ok || perr.Line != 6 || perr.Column != 12 {
+		t.Errorf("expected an error at line 6 col 12, got: %v", err)
+	}
+}
+
+func TestSubscripts(t *testing.T) {
+	type color string
+	set := pongo2.NewSet("subscripts", pongo2.NewMemoryLoader(nil))
+	set.Undefined = pongo2.UndefinedStrict
+	data := pongo2.Context{
+		"colors":  map[color]string{"red": "#f00"},
+		"codes":   map[int64]string{404: "not found"},
+		"flags":   map[uint8]bool{1: true},
+		"headers": map[string]string{"Content-Type": "text/html"},
+		"code":    404,
+	}
+
+	tpl, err := set.FromString(`{{ colors["red"] }} {{ codes[code] }} {{ codes.404 }} {{ flags[1] }} {{ headers["Content-Type"] }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "#f00 not found not found True text/html"; out != expected {
+		t.Errorf("expected '%s', got '%s'", expected, out)
+	}
+
+	// Missing keys are reported with the failing subscript
+	tpl, err = set.FromString(`{{ headers["Accept"] }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = tpl.Execute(data)
+	var undefErr *pongo2.UndefinedError
+	if !errors.As(err, &undefErr) || undefErr.Variable != `headers["Accept"]` || undefErr.Segment != `["Accept"]` {
+		t.Errorf("expected an *UndefinedError for headers[\"Accept\"], got: %v", err)
+	}
+
+	// Keys not representable by the map's key type don't match any key
+	data["levels"] = map[int8]string{1: "one", -1: "minus one"}
+	data["floats"] = map[float32]string{1.5: "one and a half"}
+	for _, expr := range []string{"flags[257]", "flags[-255]", "flags[1.5]", "levels[257]", "levels[-255]", "levels[1.5]", "codes[404.5]", "floats[1e300]"} {
+		tpl, err := set.FromString("{{ " + expr + " }}")
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := tpl.Execute(data)
+		if !errors.As(err, &undefErr) {
+			t.Errorf("%s: expected an *UndefinedError, got '%s' (err = %v)", expr, out, err)
+		}
+	}
+
+	// Numbers representing a key exactly match it
+	tpl, err = set.FromString(`{{ flags[1.0] }} {{ levels[-1] }} {{ codes[404.0] }} {{ floats[1.5] }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = tpl.Execute(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "True minus one not found one and a half"; out != expected {
+		t.Errorf("expected '%s', got '%s'", expected, out)
+	}
+}
+
+func TestConditionalExpressionIsLazy(t *testing.T) {
+	calls := map[string]int{}
+	count := func(name string) func() string {
+		return func() string {
+			calls[name]++
+			return name
+		}
+	}
+	tpl, err := pongo2.FromString(`{{ a() if flag else b() }}{{ c() if not flag else d() if flag else e() }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(pongo2.Context{
+		"flag": true,
+		"a":    count("a"), "b": count("b"), "c": count("c"), "d": count("d"), "e": count("e"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out != "ad" {
+		t.Errorf("expected 'ad', got '%s'", out)
+	}
+	if calls["a"] != 1 || calls["d"] != 1 || calls["b"]+calls["c"]+calls["e"] != 0 {
+		t.Errorf("untaken branches have been evaluated: %v", calls)
+	}
+}
+
+func TestFilteredLoopInformation(t *testing.T) {
+	tests := []struct {
+		tpl, out, log string
+	}{
+		// The conditions are evaluated before the body is rendered
+		{"{% for x in items if check(x) %}{{ mark(x) }}{{ forloop.Last }}{% endfor %}", "FalseTrue", "c1 c2 c3 c4 b1 b3"},
+		{"{% for x in items if check(x) %}{{ mark(x) }}{% break %}{% endfor %}", "", "c1 c2 c3 c4 b1"},
+		// The loop information describes the filtered sequence wherever it's used
+		{"{% for x in items if check(x) %}{% include 'item.tpl' %}{% endfor %}", "21", "c1 c2 c3 c4 b1 b3"},
+		{"{% for x in items if check(x) %}{% filter lower %}{{ mark(x) }}{{ forloop.Length }}{% endfilter %}{% endfor %}", "22", "c1 c2 c3 c4 b1 b3"},
+	}
+	set := pongo2.NewSet("filtered loops", pongo2.NewMemoryLoader(map[string]string{
+		"item.tpl": "{{ mark(x) }}{{ forloop.Revcounter }}",
+	}))
+	for _, test := range tests {
+		tpl, err := set.FromString(test.tpl)
+		if err != nil {
+			t.Fatalf("%s: %v", test.tpl, err)
+		}
+		var log []string
+		out, err := tpl.Execute(pongo2.Context{
+			"items": []int{1, 2, 3, 4},
+			"check": func(x int) bool {
+				log = append(log, fmt.Sprintf("c%d", x))
+				return x%2 != 0
+			},
+			"mark": func(x int) string {
+				log = append(log, fmt.Sprintf("b%d", x))
+				return ""
+			},
+		})
+		if err != nil {
+			t.Fatalf("%s: %v", test.tpl, err)
+		}
+		if out != test.out || strings.Join(log, " ") != test.log {
+			t.Errorf("%s: expected '%s' (%s), got '%s' (%s)", test.tpl, test.out, test.log, out, strings.Join(log, " "))
+		}
+	}
+}
+
+func TestFilterWithArgs(t *testing.T) {
+	set := pongo2.NewSet("filter args", pongo2.NewMemoryLoader(nil))
+	err := set.RegisterFilterWithArgs("format_money", func(in *pongo2.Value, args *pongo2.FilterArguments) (*pongo2.Value, *pongo2.Error) {
+		digits := 2
+		if d := args.Arg(1, "digits"); !d.IsNil() {
+			digits = d.Integer()
+		}
+		return pongo2.AsValue(fmt.Sprintf("%.*f %s", digits, in.Float(), args.Arg(0, "currency").String())), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tpl, err := set.FromString(`{{ 3.14159|format_money("USD") }}|{{ 3.14159|format_money(currency="EUR", digits=3) }}|{{ 2|format_money:"CHF" }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "3.14 USD|3.142 EUR|2.00 CHF"; out != expected {
+		t.Errorf("expected '%s', got '%s'", expected, out)
+	}
+
+	// Filters with arguments can be applied from Go code as well
+	v, perr := pongo2.ApplyFilter("yesno", pongo2.AsValue(false), pongo2.AsValue("ja,nein"))
+	if perr != nil || v.String() != "nein" {
+		t.Errorf("expected 'nein', got '%v' (err = %v)", v, perr)
+	}
+}
+
+type buttonOptions struct {
+	Kind     string
+	Disabled bool `pongo2:"is_disabled"`
+}
+
+func TestKeywordArguments(t *testing.T) {
+	ctx := pongo2.Context{
+		"button": func(label string, opts buttonOptions) string {
+			return fmt.Sprintf("%s/%s/%t", label, opts.Kind, opts.Disabled)
+		},
+		"link": func(href *pongo2.Value, kwargs map[string]*pongo2.Value) string {
+			if anchor, has := kwargs["anchor"]; has {
+				return fmt.Sprintf("%s#%s", href.String(), anchor.String())
+			}
+			return href.String()
+		},
+		"plain": func(s string) string { return s },
+		"since": func(s string, t time.Time) string { return s + t.String() },
+	}
+
+	tpl, err := pongo2.FromString(`{{ button("Save", kind="primary", is_disabled=true) }}|{{ button("Cancel", is_disabled=false) }}|{{ link("/a", anchor="top") }}|{{ link("/b") }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "Save/primary/true|Cancel//false|/a#top|/b"; out != expected {
+		t.Errorf("expected '%s', got '%s'", expected, out)
+	}
+
+	tpl, err = pongo2.FromString(`{{ plain("x", foo=1) }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = tpl.Execute(ctx); err == nil || !strings.Contains(err.Error(), "does not accept keyword arguments") {
+		t.Errorf("expected keyword argument error, got %v", err)
+	}
+
+	// Without keyword arguments a trailing struct is an ordinary parameter
+	for _, src := range []string{`{{ button("Cancel") }}`, `{{ since("x") }}`} {
+		tpl, err = pongo2.FromString(src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = tpl.Execute(ctx); err == nil || !strings.Contains(err.Error(), "must be equal to the calling argument count") {
+			t.Errorf("%s: expected argument count error, got %v", src, err)
+		}
+	}
+
+	tpl, err = pongo2.FromString(`{% macro field(name, type="text") %}{{ name }}:{{ type }}{% endmacro %}{{ field(type="email", name="mail") }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err = tpl.Execute(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "mail:email"; out != expected {
+		t.Errorf("expected '%s', got '%s'", expected, out)
+	}
+}
+
+func TestIsOperator(t *testing.T) {
+	set := pongo2.NewSet("tests", pongo2.NewMemoryLoader(nil))
+	set.Undefined = pongo2.UndefinedStrict
+	err := set.RegisterTest("admin", func(in *pongo2.Value, params []*pongo2.Value) (bool, *pongo2.Error) {
+		u, ok := in.Interface().(*user)
+		return ok && isAdmin(u), nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := set.BanTest("sameas"); err != nil {
+		t.Fatal(err)
+	}
+
+	// Undefined variables can be tested even in strict mode
+	tpl, err := set.FromString(`{{ usr is admin }} {{ missing is defined }} {{ missing.name is not defined }}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := tpl.Execute(pongo2.Context{"usr": &user{Name: "user2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := "True False True"; out != expected {
+		t.Errorf("expected '%s', got '%s'", expected, out)
+	}
+
+	if _, err := set.FromString(`{{ 1 is sameas 1 }}`); err == nil || !strings.Contains(err.Error(), "sandbox restriction active") {
+		t.Errorf("expected sandbox error, got %v", err)
+	}
+	if err := set.BanTest("odd"); err == nil {
+		t.Error("expected an error when banning tests after the first template has been created")
+	}
+}
+
+func TestDynamicExtends(t *testing.T) {
+	set := pongo2.NewSet("dynamic extends", pongo2.NewMemoryLoader(map[string]string{
+		"base.html":  `[base:{% block content %}{% endblock %}]`,
+		"ajax.html":  `[ajax:{% block content %}{% endblock %}]`,
+		"page.html":  `{% extends layout %}{% block content %}page{% endblock %}`,
+		"other.html": `{% extends "ajax.html" if is_ajax else "base.html" %}{% block content %}other{% endblock %}`,
+		"loop1.html": `{% extends next %}{% block content %}1{% endblock %}`,
+		"loop2.html": `{% extends "loop1.html" %}`,
+	}))
+
+	render := func(name string, ctx pongo2.Context) (string, error) {
+		tpl, err := set.FromCache(name)
+		if err != nil {
+			return "", err
+		}
+		return tpl.Execute(ctx)
+	}
+
+	// Templates sharing the same parent get their own blocks on every execution
+	for _, c := range []struct {
+		name     string
+		ctx      pongo2.Context
+		expected string
+	}{
+		{"page.html", pongo2.Context{"layout": "base.html"}, "[base:page]"},
+		{"page.html", pongo2.Context{"layout": "ajax.html"}, "[ajax:page]"},
+		{"other.html", pongo2.Context{"is_ajax": true}, "[ajax:other]"},
+		{"other.html", pongo2.Context{"is_ajax": false}, "[base:other]"},
+		{"page.html", pongo2.Context{"layout": "base.html"}, "[base:page]"},
+	} {
+		out, err := render(c.name, c.ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != c.expected {
+			t.Errorf("%s: expected '%s', got '%s'", c.name, c.expected, out)
+		}
+	}
+
+	// Compiled templates can be used as parents as well
+	base, err := set.FromCache("base.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := render("page.html", pongo2.Context{"layout": base}); err != nil || out != "[base:page]" {
+		t.Errorf("expected '[base:page]', got '%s' (err = %v)", out, err)
+	}
+
+	if _, err := render("page.html", nil); err == nil || !strings.Contains(err.Error(), "evaluated to an empty string") {
+		t.Errorf("expected empty template name error, got %v", err)
+	}
+	if _, err := render("loop1.html", pongo2.Context{"next": "loop2.html"}); err == nil || !strings.Contains(err.Error(), "recursively extends itself") {
+		t.Errorf("expected recursion error, got %v", err)
+	}
+}
+
+// TestTemplatesConcurrently executes all templates of template_tests
+// concurrently, sharing one compiled template per file (like FromCache()
+// does). Run it with -race to detect data races.
+func TestTemplatesConcurrently(t *testing.T) {
+	pongo2.Globals["this_is_a_global_variable"] = "this is a global text"
+
+	matches, err := filepath.Glob("./template_tests/*.tpl")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type testCase struct {
+		name     string
+		tpl      *pongo2.Template
+		expected []byte
+	}
+	cases := make([]testCase, 0, len(matches))
+	for _, match := range matches {
+		tpl, err := pongo2.FromCache(match)
+		if err != nil {
+			t.Fatalf("Error on FromCache('%s'): %s", match, err.Error())
+		}
+		expected, err := ioutil.ReadFile(fmt.Sprintf("%s.out", match))
+		if err != nil {
+			t.Fatal(err)
+		}
+		cases = append(cases, testCase{name: match, tpl: tpl, expected: expected})
+	}
+
+	const workers = 8
+	const rounds = 3
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds*len(cases))
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for r := 0; r < rounds; r++ {
+				for i := range cases {
+					// Every worker uses a different order
+					c := cases[(i+w)%len(cases)]
+					out, err := c.tpl.ExecuteBytes(tplContext)
+					if err != nil {
+						errs <- fmt.Errorf("%s: %v", c.name, err)
+						continue
+					}
+					if !bytes.Equal(out, c.expected) {
+						errs <- fmt.Errorf("%s: output differs from %s.out:\n%s", c.name, c.name, out)
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+func TestExecuteBlock(t *testing.T) {
+	set := pongo2.NewSet("blocks", pongo2.NewMemoryLoader(map[string]string{
+		"base.html": `<html>{% block title %}Base{% endblock %}|{% block content %}<p>{% block inner %}base inner{% endblock %}</p>{% endblock %}</html>`,
+		"page.html": `{% extends "base.html" %}{% block content %}[{{ block.Super|safe }}] {{ name }}{% endblock %}{% block sidebar %}unused{% endblock %}`,
+	}))
+	tpl, err := set.FromFile("page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{
+		"title":   "Base",
+		"content": "[<p>base inner</p>] fragment",
+		"inner":   "base inner",
+		"sidebar": "unused",
+	} {
+		out, err := tpl.ExecuteBlock(name, pongo2.Context{"name": "fragment"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out != expected {
+			t.Errorf("block %s: expected '%s', got '%s'", name, expected, out)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tpl.ExecuteBlockWriter("title", nil, &buf); err != nil || buf.String() != "Base" {
+		t.Errorf("expected 'Base', got '%s' (err = %v)", buf.String(), err)
+	}
+	buf.Reset()
+	if err := tpl.ExecuteBlockWriter("missing", nil, &buf); err == nil || !strings.Contains(err.Error(), "block 'missing' does not exist") {
+		t.Errorf("expected missing block error, got %v", err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("nothing must be written on error, got '%s'", buf.String())
+	}
+
+	// Blocks can be canceled or given a deadline as well
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := tpl.ExecuteBlockContext(ctx, "content", nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("expected context.Canceled, got: %v", err)
+	}
+	deadlineCtx, cancelDeadline := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancelDeadline()
+	if err := tpl.ExecuteBlockWriterContext(deadlineCtx, "title", nil, &buf); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("expected context.DeadlineExceeded, got: %v", err)
+	}
+	if buf.Len() > 0 {
+		t.Errorf("nothing must be written on error, got '%s'", buf.String())
+	}
+	out, err := tpl.ExecuteBlockContext(context.Background(), "content", pongo2.Context{"name": "fragment"})
+	if err != nil || out != "[<p>base inner</p>] fragment" {
+		t.Errorf("expected '[<p>base inner</p>] fragment', got '%s' (err = %v)", out, err)
+	}
+
+	if blocks := strings.Join(tpl.Blocks(), ","); blocks != "content,inner,sidebar,title" {
+		t.Errorf("expected blocks 'content,inner,sidebar,title', got '%s'", blocks)
+	}
+}
+
+type tagASTDemoNode struct {
+	expr pongo2.IEvaluator
+}
+
+func (node *tagASTDemoNode) Execute(ctx *pongo2.ExecutionContext, writer pongo2.TemplateWriter) *pongo2.Error {
+	return nil
+}
+
+func (node *tagASTDemoNode) Children() []pongo2.Node {
+	return []pongo2.Node{node.expr}
+}
+
+func TestTemplateAST(t *testing.T) {
+	set := pongo2.NewSet("ast", pongo2.NewMemoryLoader(map[string]string{
+		"header.html": `<h1>{{ title }}</h1>`,
+		"page.html": `{% include "header.html" with title=page.title %}
+{% for item in items if item.visible %}{{ item.name|upper|default:fallback }}{% empty %}{{ empty_text }}{% endfor %}
+{% demo user.id %}{% include layout %}`,
+	}))
+	err := set.RegisterTag("demo", func(doc *pongo2.Parser, start *pongo2.Token, arguments *pongo2.Parser) (pongo2.INodeTag, *pongo2.Error) {
+		expr, err := arguments.ParseExpression()
+		if err != nil {
+			return nil, err
+		}
+		return &tagASTDemoNode{expr: expr}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tpl, err := set.FromFile("page.html")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tags, variables, filters, includes []string
+	var forToken *pongo2.Token
+	pongo2.Inspect(tpl.Root(), func(node pongo2.Node) bool {
+		switch n := node.(type) {
+		case pongo2.TagNode:
+			tags = append(tags, n.TagName())
+			if ref, ok := n.Tag().(pongo2.FileReference); ok {
+				includes = append(includes, ref.Filename())
+			}
+			if n.TagName() == "for" {
+				forToken = n.GetPositionToken()
+			}
+		case pongo2.VariableNode:
+			variables = append(variables, n.VariableName())
+		case pongo2.FilterNode:
+			filters = append(filters, n.FilterName())
+		}
+		return true
+	})
+
+	for _, check := range []struct {
+		what     string
+		got      []string
+		expected string
+	}{
+		{"tags", tags, "include,for,demo,include"},
+		{"variables", variables, "page.title,items,item.visible,item.name,fallback,empty_text,user.id,layout"},
+		{"filters", filters, "upper,default"},
+		{"includes", includes, "header.html,"},
+	} {
+		if got := strings.Join(check.got, ","); got != check.expected {
+			t.Errorf("%s: expected '%s', got '%s'", check.what, check.expected, got)
+		}
+	}
+	if forToken == nil || forToken.Line != 2 {
+		t.Errorf("expected the for-tag on line 2, got %v", forToken)
+	}
+
+	// Stop descending into the for-loop
+	variables = nil
+	pongo2.Inspect(tpl.Root(), func(node pongo2.Node) bool {
+		if v, ok := node.(pongo2.VariableNode); ok {
+			variables = append(variables, v.VariableName())
+		}
+		tag, ok := node.(pongo2.TagNode)
+		return !ok || tag.TagName() != "for"
+	})
+	if got := strings.Join(variables, ","); got != "page.title,user.id,layout" {
+		t.Errorf("expected variables 'page.title,user.id,layout', got '%s'", got)
+	}
+}
+
 func BenchmarkCache(b *testing.B) {
 	cacheSet := pongo2.NewSet("cache set", pongo2.MustNewLocalFileSystemLoader(""))
 	for i := 0; i < b.N; i++ {
@@ -21,6 +21,7 @@ package pongo2
 
 import (
 	"fmt"
+	"sync"
 
 	"github.com/juju/errors"
 )
@@ -49,7 +50,10 @@ type tag struct {
 	parser TagParser
 }
 
-var tags map[string]*tag
+var (
+	tags      map[string]*tag
+	tagsMutex sync.RWMutex
+)
 
 func init() {
 	tags = make(map[string]*tag)
@@ -61,7 +65,13 @@ func init() {
 //
 // See http://www.florian-schlachter.de/post/pongo2/ for more about
 // writing filters and tags.
+//
+// A tag's node can implement a `Children() []Node` method to make the nodes
+// it contains (e. g. its arguments and its body) available to Walk() and
+// Inspect(); see ParentNode.
 func RegisterTag(name string, parserFn TagParser) error {
+	tagsMutex.Lock()
+	defer tagsMutex.Unlock()
 	_, existing := tags[name]
 	if existing {
 		return errors.Errorf("tag with name '%s' is already registered", name)
@@ -76,6 +86,8 @@ func RegisterTag(name string, parserFn TagParser) error {
 // Replaces an already registered tag with a new implementation. Use this
 // function with caution since it allows you to change existing tag behaviour.
 func ReplaceTag(name string, parserFn TagParser) error {
+	tagsMutex.Lock()
+	defer tagsMutex.Unlock()
 	_, existing := tags[name]
 	if !existing {
 		return errors.Errorf("tag with name '%s' does not exist (therefore cannot be overridden)", name)
@@ -87,6 +99,13 @@ func ReplaceTag(name string, parserFn TagParser) error {
 	return nil
 }
 
+func getTag(name string) (*tag, bool) {
+	tagsMutex.RLock()
+	defer tagsMutex.RUnlock()
+	t, existing := tags[name]
+	return t, existing
+}
+
 // Tag = "{%" IDENT ARGS "%}"
 func (p *Parser) parseTagElement() (INodeTag, *Error) {
 	p.Consume() // consume "{%"
@@ -98,7 +117,7 @@ func (p *Parser) parseTagElement() (INodeTag, *Error) {
 	}
 
 	// Check for the existing tag
-	tag, exists := tags[tokenName.Val]
+	tag, exists := p.template.set.getTag(tokenName.Val)
 	if !exists {
 		// Does not exists
 		return nil, p.Error(fmt.Sprintf("Tag '%s' not found (or beginning tag not provided)", tokenName.Val), tokenName)
@@ -131,5 +150,9 @@ func (p *Parser) parseTagElement() (INodeTag, *Error) {
 
 	p.template.level++
 	defer func() { p.template.level-- }()
-	return tag.parser(p, tokenName, argParser)
+	node, err := tag.parser(p, tokenName, argParser)
+	if err != nil {
+		return nil, err
+	}
+	return &nodeTag{token: tokenName, tag: node}, nil
 }
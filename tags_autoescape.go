@@ -5,6 +5,10 @@ type tagAutoescapeNode struct {
 	autoescape bool
 }
 
+func (node *tagAutoescapeNode) Children() []Node {
+	return appendNodes(nil, node.wrapper)
+}
+
 func (node *tagAutoescapeNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	old := ctx.Autoescape
 	ctx.Autoescape = node.autoescape
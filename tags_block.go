@@ -6,19 +6,27 @@ import (
 )
 
 type tagBlockNode struct {
-	name string
+	name    string
+	wrapper *NodeWrapper // the block's content within this template
 }
 
-func (node *tagBlockNode) getBlockWrappers(tpl *Template) []*NodeWrapper {
+func (node *tagBlockNode) Children() []Node {
+	return appendNodes(nil, node.wrapper)
+}
+
+func (node *tagBlockNode) getBlockWrappers(ctx *ExecutionContext) []*NodeWrapper {
 	nodeWrappers := make([]*NodeWrapper, 0)
 	var t *NodeWrapper
 
-	for tpl != nil {
+	chain := ctx.inheritance
+	if chain == nil {
+		chain = []*Template{ctx.template}
+	}
+	for _, tpl := range chain {
 		t = tpl.blocks[node.name]
 		if t != nil {
 			nodeWrappers = append(nodeWrappers, t)
 		}
-		tpl = tpl.child
 	}
 
 	return nodeWrappers
@@ -31,7 +39,7 @@ func (node *tagBlockNode) Execute(ctx *ExecutionContext, writer TemplateWriter)
 	}
 
 	// Determine the block to execute
-	blockWrappers := node.getBlockWrappers(tpl)
+	blockWrappers := node.getBlockWrappers(ctx)
 	lenBlockWrappers := len(blockWrappers)
 
 	if lenBlockWrappers == 0 {
@@ -121,7 +129,7 @@ func tagBlockParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Er
 		return nil, arguments.Error(fmt.Sprintf("Block named '%s' already defined", nameToken.Val), nil)
 	}
 
-	return &tagBlockNode{name: nameToken.Val}, nil
+	return &tagBlockNode{name: nameToken.Val, wrapper: wrapper}, nil
 }
 
 func init() {
@@ -0,0 +1,29 @@
+package pongo2
+
+type tagBreakNode struct {
+	position *Token
+}
+
+func (node *tagBreakNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
+	// Handled by the nearest enclosing for-loop (see tagForNode)
+	return ctx.OrigError(errLoopBreak, node.position)
+}
+
+func (node *tagBreakNode) GetPositionToken() *Token {
+	return node.position
+}
+
+func tagBreakParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
+	if arguments.Remaining() > 0 {
+		return nil, arguments.Error("Tag 'break' does not take any argument.", nil)
+	}
+	if doc.loopDepth == 0 {
+		return nil, doc.Error("Tag 'break' is only allowed within a for-loop.", start)
+	}
+
+	return &tagBreakNode{position: start}, nil
+}
+
+func init() {
+	RegisterTag("break", tagBreakParser)
+}
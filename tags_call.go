@@ -0,0 +1,100 @@
+package pongo2
+
+type tagCallNode struct {
+	position *Token
+	callee   *nodeVariable
+
+	// The body of the call-block is available as an anonymous
+	// macro named caller within the called macro.
+	caller *tagMacroNode
+}
+
+func (node *tagCallNode) Children() []Node {
+	return append(appendNodes(nil, node.callee), node.caller.Children()...)
+}
+
+// callerArgument passes the body of the call-block as keyword argument
+// 'caller' to the called macro.
+type callerArgument struct{}
+
+func (callerArgument) Evaluate(ctx *ExecutionContext) (*Value, *Error) {
+	return AsValue(ctx.caller), nil
+}
+
+func (node *tagCallNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
+	callCtx := NewChildExecutionContext(ctx)
+	callCtx.caller = node.caller.function(ctx)
+
+	return node.callee.Execute(callCtx, writer)
+}
+
+func (node *tagCallNode) GetPositionToken() *Token {
+	return node.position
+}
+
+func tagCallParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
+	callNode := &tagCallNode{
+		position: start,
+		caller: &tagMacroNode{
+			position: start,
+			name:     "caller",
+			args:     make(map[string]IEvaluator),
+		},
+	}
+
+	// Arguments of caller (optional)
+	if arguments.PeekOne(TokenSymbol, "(") != nil {
+		if err := parseMacroArguments(arguments, callNode.caller); err != nil {
+			return nil, err
+		}
+	}
+
+	calleeToken := arguments.Current()
+	expr, err := arguments.parseVariableOrLiteral()
+	if err != nil {
+		return nil, err
+	}
+	resolver, isResolver := expr.(*variableResolver)
+	if !isResolver || !resolver.parts[len(resolver.parts)-1].isFunctionCall {
+		return nil, arguments.Error("Call-tag needs a macro call (e.g. 'macro(args)').", calleeToken)
+	}
+
+	if arguments.Remaining() > 0 {
+		return nil, arguments.Error("Malformed call-tag.", nil)
+	}
+
+	part := resolver.parts[len(resolver.parts)-1]
+	for _, name := range part.callingKwargNames {
+		if name == "caller" {
+			return nil, arguments.Error("Keyword argument 'caller' is reserved for the call-block.", calleeToken)
+		}
+	}
+	part.callingKwargNames = append(part.callingKwargNames, "caller")
+	part.callingKwargs = append(part.callingKwargs, callerArgument{})
+
+	callNode.callee = &nodeVariable{
+		locationToken: calleeToken,
+		expr:          resolver,
+	}
+
+	// Body wrapping; the body is executed by the called macro and
+	// therefore not part of any surrounding loop
+	loopDepth := doc.loopDepth
+	doc.loopDepth = 0
+	wrapper, endargs, err := doc.WrapUntilTag("endcall")
+	doc.loopDepth = loopDepth
+	if err != nil {
+		return nil, err
+	}
+	callNode.caller.wrapper = wrapper
+
+	if endargs.Count() > 0 {
+		return nil, endargs.Error("Arguments not allowed here.", nil)
+	}
+
+	return callNode, nil
+}
+
+func init() {
+	RegisterTag("call", tagCallParser)
+}
@@ -0,0 +1,29 @@
+package pongo2
+
+type tagContinueNode struct {
+	position *Token
+}
+
+func (node *tagContinueNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
+	// Handled by the nearest enclosing for-loop (see tagForNode)
+	return ctx.OrigError(errLoopContinue, node.position)
+}
+
+func (node *tagContinueNode) GetPositionToken() *Token {
+	return node.position
+}
+
+func tagContinueParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
+	if arguments.Remaining() > 0 {
+		return nil, arguments.Error("Tag 'continue' does not take any argument.", nil)
+	}
+	if doc.loopDepth == 0 {
+		return nil, doc.Error("Tag 'continue' is only allowed within a for-loop.", start)
+	}
+
+	return &tagContinueNode{position: start}, nil
+}
+
+func init() {
+	RegisterTag("continue", tagContinueParser)
+}
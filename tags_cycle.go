@@ -8,18 +8,33 @@ type tagCycleValue struct {
 type tagCycleNode struct {
 	position *Token
 	args     []IEvaluator
-	idx      int
 	asName   string
 	silent   bool
 }
 
+func (node *tagCycleNode) Children() []Node {
+	return appendNodes(nil, node.args)
+}
+
+// tagCycleState is the state of a cycle-tag within one execution.
+type tagCycleState struct {
+	idx int
+}
+
+// next returns the next item of the cycle.
+func (node *tagCycleNode) next(ctx *ExecutionContext) IEvaluator {
+	state := ctx.nodeState(node, func() interface{} { return &tagCycleState{} }).(*tagCycleState)
+	item := node.args[state.idx%len(node.args)]
+	state.idx++
+	return item
+}
+
 func (cv *tagCycleValue) String() string {
 	return cv.value.String()
 }
 
 func (node *tagCycleNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
-	item := node.args[node.idx%len(node.args)]
-	node.idx++
+	item := node.next(ctx)
 
 	val, err := item.Evaluate(ctx)
 	if err != nil {
@@ -31,8 +46,7 @@ func (node *tagCycleNode) Execute(ctx *ExecutionContext, writer TemplateWriter)
 		// {% cycle cycleitem %}
 
 		// Update the cycle value with next value
-		item := t.node.args[t.node.idx%len(t.node.args)]
-		t.node.idx++
+		item := t.node.next(ctx)
 
 		val, err := item.Evaluate(ctx)
 		if err != nil {
@@ -63,6 +77,10 @@ func (node *tagCycleNode) Execute(ctx *ExecutionContext, writer TemplateWriter)
 	return nil
 }
 
+func (node *tagCycleNode) GetPositionToken() *Token {
+	return node.position
+}
+
 // HINT: We're not supporting the old comma-separated list of expressions argument-style
 func tagCycleParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
 	cycleNode := &tagCycleNode{
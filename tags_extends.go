@@ -1,49 +1,140 @@
 package pongo2
 
+import (
+	"fmt"
+)
+
 type tagExtendsNode struct {
-	filename string
+	position        *Token
+	filename        string
+	parent          *Template  // only for static extends: {% extends "base.html" %}
+	parentEvaluator IEvaluator // only for dynamic extends: {% extends layout %}
+}
+
+func (node *tagExtendsNode) Children() []Node {
+	return appendNodes(nil, node.parentEvaluator)
+}
+
+func (node *tagExtendsNode) Filename() string {
+	return node.filename
 }
 
 func (node *tagExtendsNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	return nil
 }
 
+func (node *tagExtendsNode) GetPositionToken() *Token {
+	return node.position
+}
+
+// parentTemplate returns the template extended by child. Dynamic parents
+// are evaluated within the given context and compiled through the set's cache.
+func (node *tagExtendsNode) parentTemplate(ctx *ExecutionContext, child *Template) (*Template, *Error) {
+	if node.parent != nil {
+		return node.parent, nil
+	}
+
+	value, err := node.parentEvaluator.Evaluate(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// The parent can be given as a compiled template as well
+	if tpl, isTemplate := value.Interface().(*Template); isTemplate && tpl != nil {
+		return tpl, nil
+	}
+
+	if value.String() == "" {
+		return nil, ctx.Error("Template name for 'extends'-tag evaluated to an empty string.", node.position)
+	}
+
+	parentFilename := child.set.resolveFilename(child, value.String())
+	parent, err2 := child.set.FromCache(parentFilename)
+	if err2 != nil {
+		return nil, err2.(*Error).updateFromTokenIfNeeded(child, node.position)
+	}
+	return parent, nil
+}
+
+// inheritanceChain returns all templates tpl inherits from, starting with the
+// root template (the one to be executed) and ending with tpl itself.
+func (tpl *Template) inheritanceChain(ctx *ExecutionContext) ([]*Template, *Error) {
+	maxDepth := tpl.set.MaxRecursionDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxRecursionDepth
+	}
+
+	chain := []*Template{tpl}
+	for t := tpl; t.extends != nil; {
+		parent, err := t.extends.parentTemplate(ctx, t)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, c := range chain {
+			if c == parent || (!c.isTplString && c.name == parent.name) {
+				return nil, ctx.Error(fmt.Sprintf("template '%s' recursively extends itself", parent.displayName), t.extends.position)
+			}
+		}
+		if len(chain) >= maxDepth {
+			return nil, ctx.OrigError(&LimitError{Limit: "recursion depth", Max: maxDepth}, t.extends.position)
+		}
+
+		chain = append([]*Template{parent}, chain...)
+		t = parent
+	}
+
+	return chain, nil
+}
+
 func tagExtendsParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
-	extendsNode := &tagExtendsNode{}
+	extendsNode := &tagExtendsNode{
+		position: start,
+	}
 
 	if doc.template.level > 1 {
 		return nil, arguments.Error("The 'extends' tag can only defined on root level.", start)
 	}
 
-	if doc.template.parent != nil {
+	if doc.template.extends != nil {
 		// Already one parent
 		return nil, arguments.Error("This template has already one parent.", start)
 	}
 
-	if filenameToken := arguments.MatchType(TokenString); filenameToken != nil {
+	if arguments.Count() == 0 {
+		return nil, arguments.Error("Tag 'extends' requires a template filename (as string or expression).", nil)
+	}
+
+	if filenameToken := arguments.PeekType(TokenString); filenameToken != nil && arguments.Count() == 1 {
 		// prepared, static template
+		arguments.Consume()
 
 		// Get parent's filename
 		parentFilename := doc.template.set.resolveFilename(doc.template, filenameToken.Val)
 
 		// Parse the parent
-		parentTemplate, err := doc.template.set.FromFile(parentFilename)
+		parentTemplate, err := doc.template.compileDependency(parentFilename)
 		if err != nil {
-			return nil, err.(*Error)
+			return nil, err.(*Error).updateFromTokenIfNeeded(doc.template, filenameToken)
 		}
 
-		// Keep track of things
-		parentTemplate.child = doc.template
-		doc.template.parent = parentTemplate
-		extendsNode.filename = parentFilename
+		extendsNode.parent = parentTemplate
+		extendsNode.filename = doc.template.set.displayName(parentFilename)
 	} else {
-		return nil, arguments.Error("Tag 'extends' requires a template filename as string.", nil)
+		// dynamic template, evaluated at execution time
+		parentEvaluator, err := arguments.ParseExpression()
+		if err != nil {
+			return nil, err
+		}
+		extendsNode.parentEvaluator = parentEvaluator
 	}
 
 	if arguments.Remaining() > 0 {
 		return nil, arguments.Error("Tag 'extends' does only take 1 argument.", nil)
 	}
 
+	doc.template.extends = extendsNode
+
 	return extendsNode, nil
 }
 
@@ -4,38 +4,29 @@ import (
 	"bytes"
 )
 
-type nodeFilterCall struct {
-	name      string
-	paramExpr IEvaluator
-}
-
 type tagFilterNode struct {
 	position    *Token
 	bodyWrapper *NodeWrapper
-	filterChain []*nodeFilterCall
+	filterChain []*filterCall
+}
+
+func (node *tagFilterNode) Children() []Node {
+	return appendNodes(nil, node.filterChain, node.bodyWrapper)
 }
 
 func (node *tagFilterNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	temp := bytes.NewBuffer(make([]byte, 0, 1024)) // 1 KiB size
 
-	err := node.bodyWrapper.Execute(ctx, temp)
-	if err != nil {
-		return err
+	loopErr := node.bodyWrapper.Execute(ctx, temp)
+	if loopErr != nil && !isLoopControl(loopErr) {
+		return loopErr
 	}
 
 	value := AsValue(temp.String())
 
 	for _, call := range node.filterChain {
-		var param *Value
-		if call.paramExpr != nil {
-			param, err = call.paramExpr.Evaluate(ctx)
-			if err != nil {
-				return err
-			}
-		} else {
-			param = AsValue(nil)
-		}
-		value, err = ApplyFilter(call.name, value, param)
+		var err *Error
+		value, err = call.Execute(value, ctx)
 		if err != nil {
 			return ctx.Error(err.Error(), node.position)
 		}
@@ -43,7 +34,8 @@ func (node *tagFilterNode) Execute(ctx *ExecutionContext, writer TemplateWriter)
 
 	writer.WriteString(value.String())
 
-	return nil
+	// Pass break and continue on after the content has been written
+	return loopErr
 }
 
 func tagFilterParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
@@ -58,22 +50,12 @@ func tagFilterParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *E
 	filterNode.bodyWrapper = wrapper
 
 	for arguments.Remaining() > 0 {
-		filterCall := &nodeFilterCall{}
-
-		nameToken := arguments.MatchType(TokenIdentifier)
-		if nameToken == nil {
+		if arguments.PeekType(TokenIdentifier) == nil {
 			return nil, arguments.Error("Expected a filter name (identifier).", nil)
 		}
-		filterCall.name = nameToken.Val
-
-		if arguments.MatchOne(TokenSymbol, ":") != nil {
-			// Filter parameter
-			// NOTICE: we can't use ParseExpression() here, because it would parse the next filter "|..." as well in the argument list
-			expr, err := arguments.parseVariableOrLiteral()
-			if err != nil {
-				return nil, err
-			}
-			filterCall.paramExpr = expr
+		filterCall, err := arguments.parseFilter()
+		if err != nil {
+			return nil, err
 		}
 
 		filterNode.filterChain = append(filterNode.filterChain, filterCall)
@@ -1,20 +1,25 @@
 package pongo2
 
 type tagFirstofNode struct {
-	position *Token
-	args     []IEvaluator
+	position      *Token
+	args          []IEvaluator
+	truthOperands [][]*variableResolver // of each argument (see evaluateCondition)
+}
+
+func (node *tagFirstofNode) Children() []Node {
+	return appendNodes(nil, node.args)
 }
 
 func (node *tagFirstofNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
-	for _, arg := range node.args {
-		val, err := arg.Evaluate(ctx)
+	for idx, arg := range node.args {
+		val, err := ctx.evaluateCondition(arg, node.truthOperands[idx])
 		if err != nil {
 			return err
 		}
 
 		if val.IsTrue() {
 			if ctx.Autoescape && !arg.FilterApplied("safe") {
-				val, err = ApplyFilter("escape", val, nil)
+				val, err = ctx.escape(val)
 				if err != nil {
 					return err
 				}
@@ -39,6 +44,7 @@ func tagFirstofParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *
 			return nil, err
 		}
 		firstofNode.args = append(firstofNode.args, node)
+		firstofNode.truthOperands = append(firstofNode.truthOperands, truthOperands(nil, node))
 	}
 
 	return firstofNode, nil
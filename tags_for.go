@@ -1,9 +1,30 @@
 package pongo2
 
+import (
+	"errors"
+	"fmt"
+	"reflect"
+)
+
+// Returned (as OrigError) by the break- and continue-tags to stop
+// the execution of the loop body.
+var (
+	errLoopBreak    = errors.New("'break' outside of a for-loop")
+	errLoopContinue = errors.New("'continue' outside of a for-loop")
+)
+
+// isLoopControl reports whether err has been returned by the break- or
+// continue-tag. Tags buffering their body output (or assign) the content
+// rendered so far before passing such an error on to the loop.
+func isLoopControl(err *Error) bool {
+	return err != nil && (err.OrigError == errLoopBreak || err.OrigError == errLoopContinue)
+}
+
 type tagForNode struct {
-	key             string
-	value           string // only for maps: for key, value in map
+	position        *Token
+	names           []string // loop variables: for key, value in map; for a, b in pairs
 	objectEvaluator IEvaluator
+	ifCondition     IEvaluator // optional: for item in items if condition
 	reversed        bool
 	sorted          bool
 
@@ -11,6 +32,10 @@ type tagForNode struct {
 	emptyWrapper *NodeWrapper
 }
 
+func (node *tagForNode) Children() []Node {
+	return appendNodes(nil, node.objectEvaluator, node.ifCondition, node.bodyWrapper, node.emptyWrapper)
+}
+
 type tagForLoopInformation struct {
 	Counter     int
 	Counter0    int
@@ -18,22 +43,58 @@ type tagForLoopInformation struct {
 	Revcounter0 int
 	First       bool
 	Last        bool
+	Length      int
+	Depth       int
+	Depth0      int
+	Previtem    *Value
+	Nextitem    *Value
 	Parentloop  *tagForLoopInformation
+
+	// Values of the last call of Changed()
+	changedValues []*Value
 }
 
-func (node *tagForNode) Execute(ctx *ExecutionContext, writer TemplateWriter) (forError *Error) {
+// Cycle returns one of the given values, cycling through them
+// with each iteration.
+func (loop *tagForLoopInformation) Cycle(values ...*Value) *Value {
+	if len(values) == 0 {
+		return AsValue(nil)
+	}
+	return values[loop.Counter0%len(values)]
+}
+
+// Changed returns true if it's called the first time or the given
+// values differ from the values of the previous call.
+func (loop *tagForLoopInformation) Changed(values ...*Value) bool {
+	changed := loop.changedValues == nil || len(values) != len(loop.changedValues)
+	for idx := 0; !changed && idx < len(values); idx++ {
+		changed = !values[idx].EqualValueTo(loop.changedValues[idx])
+	}
+	loop.changedValues = values
+	return changed
+}
+
+// tagForItem is an item of the loop; value is only set for maps.
+type tagForItem struct {
+	key, value *Value
+}
+
+func (node *tagForNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	// Backup forloop (as parentloop in public context), key-name and value-name
 	forCtx := NewChildExecutionContext(ctx)
 	parentloop := forCtx.Private["forloop"]
 
 	// Create loop struct
 	loopInfo := &tagForLoopInformation{
-		First: true,
+		Depth:  1,
+		Depth0: 0,
 	}
 
 	// Is it a loop in a loop?
 	if parentloop != nil {
 		loopInfo.Parentloop = parentloop.(*tagForLoopInformation)
+		loopInfo.Depth = loopInfo.Parentloop.Depth + 1
+		loopInfo.Depth0 = loopInfo.Parentloop.Depth0 + 1
 	}
 
 	// Register loopInfo in public context
@@ -44,61 +105,224 @@ func (node *tagForNode) Execute(ctx *ExecutionContext, writer TemplateWriter) (f
 		return err
 	}
 
-	obj.IterateOrder(func(idx, count int, key, value *Value) bool {
-		// There's something to iterate over (correct type and at least 1 item)
+	// Renders the body for an item; next is the following item (nil for the
+	// last one) and count the number of items
+	rendered := 0
+	previtem := AsValue(nil)
+	render := func(item tagForItem, next *tagForItem, count int) (bool, *Error) {
+		// Stop iterating if the execution has been canceled or
+		// the set's MaxLoopIterations has been exceeded
+		if err := forCtx.checkAborted(node.position); err != nil {
+			return false, err
+		}
+		if err := forCtx.addLoopIterations(1, node.position); err != nil {
+			return false, err
+		}
 
 		// Update loop infos and public context
-		forCtx.Private[node.key] = key
-		if value != nil {
-			forCtx.Private[node.value] = value
+		if err := node.assign(forCtx, item); err != nil {
+			return false, err
 		}
+		idx := rendered
 		loopInfo.Counter = idx + 1
 		loopInfo.Counter0 = idx
-		if idx == 1 {
-			loopInfo.First = false
-		}
-		if idx+1 == count {
-			loopInfo.Last = true
+		loopInfo.Revcounter = count - idx
+		loopInfo.Revcounter0 = count - (idx + 1)
+		loopInfo.First = idx == 0
+		loopInfo.Last = next == nil
+		loopInfo.Length = count
+		loopInfo.Previtem = previtem
+		loopInfo.Nextitem = AsValue(nil)
+		if next != nil {
+			loopInfo.Nextitem = next.key
 		}
-		loopInfo.Revcounter = count - idx        // TODO: Not sure about this, have to look it up
-		loopInfo.Revcounter0 = count - (idx + 1) // TODO: Not sure about this, have to look it up
+		rendered++
+		previtem = item.key
 
 		// Render elements with updated context
 		err := node.bodyWrapper.Execute(forCtx, writer)
 		if err != nil {
-			forError = err
+			switch err.OrigError {
+			case errLoopContinue:
+				return true, nil
+			case errLoopBreak:
+				return false, nil
+			}
+			return false, err
+		}
+		return true, nil
+	}
+
+	if node.ifCondition != nil {
+		// The loop information describes the filtered sequence, so
+		// the matching items have to be collected before iterating
+		items, err := node.items(forCtx, obj)
+		if err != nil {
+			return err
+		}
+		for idx := range items {
+			var next *tagForItem
+			if idx+1 < len(items) {
+				next = &items[idx+1]
+			}
+			proceed, err := render(items[idx], next, len(items))
+			if err != nil {
+				return err
+			}
+			if !proceed {
+				break
+			}
+		}
+	} else {
+		// Iterate lazily, looking one item ahead
+		var (
+			pending   *tagForItem
+			total     int
+			proceed   = true
+			iterError *Error
+		)
+		obj.IterateOrder(func(idx, count int, key, value *Value) bool {
+			item := tagForItem{key: key, value: value}
+			if pending != nil {
+				proceed, iterError = render(*pending, &item, count)
+				if iterError != nil || !proceed {
+					return false
+				}
+			}
+			pending = &item
+			total = count
+			return true
+		}, func() {}, node.reversed, node.sorted)
+		if iterError != nil {
+			return iterError
+		}
+		if pending != nil && proceed {
+			if _, err := render(*pending, nil, total); err != nil {
+				return err
+			}
+		}
+	}
+
+	if rendered == 0 && node.emptyWrapper != nil {
+		// Nothing to iterate over (maybe wrong type or no items)
+		return node.emptyWrapper.Execute(forCtx, writer)
+	}
+
+	return nil
+}
+
+// items returns all items of obj for which the loop's if-condition is true.
+// Each evaluation of the condition counts as a loop iteration (see
+// TemplateSet.MaxLoopIterations).
+func (node *tagForNode) items(forCtx *ExecutionContext, obj *Value) (items []tagForItem, itemsError *Error) {
+	obj.IterateOrder(func(idx, count int, key, value *Value) bool {
+		if err := forCtx.checkAborted(node.position); err != nil {
+			itemsError = err
+			return false
+		}
+		if err := forCtx.addLoopIterations(1, node.position); err != nil {
+			itemsError = err
+			return false
+		}
+
+		item := tagForItem{key: key, value: value}
+		if err := node.assign(forCtx, item); err != nil {
+			itemsError = err
 			return false
 		}
+		cond, err := node.ifCondition.Evaluate(forCtx)
+		if err != nil {
+			itemsError = err
+			return false
+		}
+		if cond.IsTrue() {
+			items = append(items, item)
+		}
 		return true
-	}, func() {
-		// Nothing to iterate over (maybe wrong type or no items)
-		if node.emptyWrapper != nil {
-			err := node.emptyWrapper.Execute(forCtx, writer)
-			if err != nil {
-				forError = err
+	}, func() {}, node.reversed, node.sorted)
+
+	return items, itemsError
+}
+
+// assign sets the loop variables for the given item. Items of lists are
+// unpacked if there's more than one loop variable (for a, b in pairs).
+func (node *tagForNode) assign(forCtx *ExecutionContext, item tagForItem) *Error {
+	if len(node.names) == 1 {
+		forCtx.Private[node.names[0]] = item.key
+		return nil
+	}
+
+	if item.value != nil {
+		// Map: for key, value in map
+		if len(node.names) != 2 {
+			return forCtx.Error(fmt.Sprintf("Cannot unpack a map item into %d loop variables.", len(node.names)), node.position)
+		}
+		forCtx.Private[node.names[0]] = item.key
+		forCtx.Private[node.names[1]] = item.value
+		return nil
+	}
+
+	values, ok := unpackLoopItem(item.key)
+	if !ok || len(values) != len(node.names) {
+		return forCtx.Error(fmt.Sprintf("Cannot unpack '%v' into %d loop variables.", item.key.Interface(), len(node.names)), node.position)
+	}
+	for idx, name := range node.names {
+		forCtx.Private[name] = values[idx]
+	}
+	return nil
+}
+
+// unpackLoopItem returns the items of a slice or an array or the exported
+// fields of a struct.
+func unpackLoopItem(item *Value) ([]*Value, bool) {
+	rv := reflect.ValueOf(item.Interface())
+	if rv.Kind() == reflect.Ptr {
+		rv = rv.Elem()
+	}
+	switch rv.Kind() {
+	case reflect.Array, reflect.Slice:
+		values := make([]*Value, 0, rv.Len())
+		for i := 0; i < rv.Len(); i++ {
+			values = append(values, AsValue(rv.Index(i).Interface()))
+		}
+		return values, true
+	case reflect.Struct:
+		var values []*Value
+		for i := 0; i < rv.NumField(); i++ {
+			if rv.Type().Field(i).PkgPath != "" {
+				// unexported
+				continue
 			}
+			values = append(values, AsValue(rv.Field(i).Interface()))
 		}
-	}, node.reversed, node.sorted)
+		return values, true
+	}
+	return nil, false
+}
 
-	return forError
+func (node *tagForNode) GetPositionToken() *Token {
+	return node.position
 }
 
 func tagForParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
-	forNode := &tagForNode{}
-
-	// Arguments parsing
-	var valueToken *Token
-	keyToken := arguments.MatchType(TokenIdentifier)
-	if keyToken == nil {
-		return nil, arguments.Error("Expected an key identifier as first argument for 'for'-tag", nil)
+	forNode := &tagForNode{
+		position: start,
 	}
 
-	if arguments.Match(TokenSymbol, ",") != nil {
-		// Value name is provided
-		valueToken = arguments.MatchType(TokenIdentifier)
-		if valueToken == nil {
+	// Arguments parsing
+	for {
+		nameToken := arguments.MatchType(TokenIdentifier)
+		if nameToken == nil {
+			if len(forNode.names) == 0 {
+				return nil, arguments.Error("Expected an key identifier as first argument for 'for'-tag", nil)
+			}
 			return nil, arguments.Error("Value name must be an identifier.", nil)
 		}
+		forNode.names = append(forNode.names, nameToken.Val)
+
+		if arguments.Match(TokenSymbol, ",") == nil {
+			break
+		}
 	}
 
 	if arguments.Match(TokenKeyword, "in") == nil {
@@ -110,10 +334,6 @@ func tagForParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Erro
 		return nil, err
 	}
 	forNode.objectEvaluator = objectEvaluator
-	forNode.key = keyToken.Val
-	if valueToken != nil {
-		forNode.value = valueToken.Val
-	}
 
 	if arguments.MatchOne(TokenIdentifier, "reversed") != nil {
 		forNode.reversed = true
@@ -123,12 +343,23 @@ func tagForParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Erro
 		forNode.sorted = true
 	}
 
+	if arguments.Match(TokenIdentifier, "if") != nil {
+		// Only iterate over the items matching the condition
+		ifCondition, err := arguments.ParseExpression()
+		if err != nil {
+			return nil, err
+		}
+		forNode.ifCondition = ifCondition
+	}
+
 	if arguments.Remaining() > 0 {
 		return nil, arguments.Error("Malformed for-loop arguments.", nil)
 	}
 
 	// Body wrapping
+	doc.loopDepth++
 	wrapper, endargs, err := doc.WrapUntilTag("empty", "endfor")
+	doc.loopDepth--
 	if err != nil {
 		return nil, err
 	}
@@ -1,13 +1,25 @@
 package pongo2
 
 type tagIfNode struct {
-	conditions []IEvaluator
-	wrappers   []*NodeWrapper
+	conditions    []IEvaluator
+	truthOperands [][]*variableResolver // of each condition (see evaluateCondition)
+	wrappers      []*NodeWrapper
+}
+
+func (node *tagIfNode) Children() []Node {
+	var children []Node
+	for idx, wrapper := range node.wrappers {
+		if idx < len(node.conditions) {
+			children = appendNodes(children, node.conditions[idx])
+		}
+		children = appendNodes(children, wrapper)
+	}
+	return children
 }
 
 func (node *tagIfNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	for i, condition := range node.conditions {
-		result, err := condition.Evaluate(ctx)
+		result, err := ctx.evaluateCondition(condition, node.truthOperands[i])
 		if err != nil {
 			return err
 		}
@@ -32,6 +44,7 @@ func tagIfParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error
 		return nil, err
 	}
 	ifNode.conditions = append(ifNode.conditions, condition)
+	ifNode.truthOperands = append(ifNode.truthOperands, truthOperands(nil, condition))
 
 	if arguments.Remaining() > 0 {
 		return nil, arguments.Error("If-condition is malformed.", nil)
@@ -52,6 +65,7 @@ func tagIfParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error
 				return nil, err
 			}
 			ifNode.conditions = append(ifNode.conditions, condition)
+			ifNode.truthOperands = append(ifNode.truthOperands, truthOperands(nil, condition))
 
 			if tagArgs.Remaining() > 0 {
 				return nil, tagArgs.Error("Elif-condition is malformed.", nil)
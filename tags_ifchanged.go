@@ -6,27 +6,42 @@ import (
 
 type tagIfchangedNode struct {
 	watchedExpr []IEvaluator
-	lastValues  []*Value
-	lastContent []byte
 	thenWrapper *NodeWrapper
 	elseWrapper *NodeWrapper
 }
 
+func (node *tagIfchangedNode) Children() []Node {
+	return appendNodes(nil, node.watchedExpr, node.thenWrapper, node.elseWrapper)
+}
+
+// tagIfchangedState is the state of an ifchanged-tag within one execution.
+type tagIfchangedState struct {
+	lastValues  []*Value
+	lastContent []byte
+}
+
 func (node *tagIfchangedNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
+	state := ctx.nodeState(node, func() interface{} { return &tagIfchangedState{} }).(*tagIfchangedState)
+
 	if len(node.watchedExpr) == 0 {
 		// Check against own rendered body
 
 		buf := bytes.NewBuffer(make([]byte, 0, 1024)) // 1 KiB
-		err := node.thenWrapper.Execute(ctx, buf)
-		if err != nil {
-			return err
+		loopErr := node.thenWrapper.Execute(ctx, buf)
+		if loopErr != nil && !isLoopControl(loopErr) {
+			return loopErr
 		}
 
 		bufBytes := buf.Bytes()
-		if !bytes.Equal(node.lastContent, bufBytes) {
+		if !bytes.Equal(state.lastContent, bufBytes) {
 			// Rendered content changed, output it
 			writer.Write(bufBytes)
-			node.lastContent = bufBytes
+			state.lastContent = bufBytes
+		}
+
+		if loopErr != nil {
+			// Left to the enclosing loop
+			return loopErr
 		}
 	} else {
 		nowValues := make([]*Value, 0, len(node.watchedExpr))
@@ -39,16 +54,16 @@ func (node *tagIfchangedNode) Execute(ctx *ExecutionContext, writer TemplateWrit
 		}
 
 		// Compare old to new values now
-		changed := len(node.lastValues) == 0
+		changed := len(state.lastValues) == 0
 
-		for idx, oldVal := range node.lastValues {
+		for idx, oldVal := range state.lastValues {
 			if !oldVal.EqualValueTo(nowValues[idx]) {
 				changed = true
 				break // we can stop here because ONE value changed
 			}
 		}
 
-		node.lastValues = nowValues
+		state.lastValues = nowValues
 
 		if changed {
 			// Render thenWrapper
@@ -6,6 +6,10 @@ type tagIfEqualNode struct {
 	elseWrapper *NodeWrapper
 }
 
+func (node *tagIfEqualNode) Children() []Node {
+	return appendNodes(nil, node.var1, node.var2, node.thenWrapper, node.elseWrapper)
+}
+
 func (node *tagIfEqualNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	r1, err := node.var1.Evaluate(ctx)
 	if err != nil {
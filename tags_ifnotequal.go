@@ -6,6 +6,10 @@ type tagIfNotEqualNode struct {
 	elseWrapper *NodeWrapper
 }
 
+func (node *tagIfNotEqualNode) Children() []Node {
+	return appendNodes(nil, node.var1, node.var2, node.thenWrapper, node.elseWrapper)
+}
+
 func (node *tagIfNotEqualNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	r1, err := node.var1.Evaluate(ctx)
 	if err != nil {
@@ -10,13 +10,13 @@ type tagImportNode struct {
 	macros   map[string]*tagMacroNode // alias/name -> macro instance
 }
 
+func (node *tagImportNode) Filename() string {
+	return node.filename
+}
+
 func (node *tagImportNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	for name, macro := range node.macros {
-		func(name string, macro *tagMacroNode) {
-			ctx.Private[name] = func(args ...*Value) *Value {
-				return macro.call(ctx, args...)
-			}
-		}(name, macro)
+		ctx.Private[name] = macro.function(ctx)
 	}
 	return nil
 }
@@ -32,14 +32,15 @@ func tagImportParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *E
 		return nil, arguments.Error("Import-tag needs a filename as string.", nil)
 	}
 
-	importNode.filename = doc.template.set.resolveFilename(doc.template, filenameToken.Val)
+	filename := doc.template.set.resolveFilename(doc.template, filenameToken.Val)
+	importNode.filename = doc.template.set.displayName(filename)
 
 	if arguments.Remaining() == 0 {
 		return nil, arguments.Error("You must at least specify one macro to import.", nil)
 	}
 
 	// Compile the given template
-	tpl, err := doc.template.set.FromFile(importNode.filename)
+	tpl, err := doc.template.compileDependency(filename)
 	if err != nil {
 		return nil, err.(*Error).updateFromTokenIfNeeded(doc.template, start)
 	}
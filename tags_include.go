@@ -1,6 +1,7 @@
 package pongo2
 
 type tagIncludeNode struct {
+	position          *Token
 	tpl               *Template
 	filenameEvaluator IEvaluator
 	lazy              bool
@@ -10,6 +11,14 @@ type tagIncludeNode struct {
 	ifExists          bool
 }
 
+func (node *tagIncludeNode) Children() []Node {
+	return appendNodeMap(appendNodes(nil, node.filenameEvaluator), node.withPairs)
+}
+
+func (node *tagIncludeNode) Filename() string {
+	return node.filename
+}
+
 func (node *tagIncludeNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	// Building the context for the template
 	includeCtx := make(Context)
@@ -30,6 +39,7 @@ func (node *tagIncludeNode) Execute(ctx *ExecutionContext, writer TemplateWriter
 	}
 
 	// Execute the template
+	tpl := node.tpl
 	if node.lazy {
 		// Evaluate the filename
 		filename, err := node.filenameEvaluator.Evaluate(ctx)
@@ -44,36 +54,52 @@ func (node *tagIncludeNode) Execute(ctx *ExecutionContext, writer TemplateWriter
 		// Get include-filename
 		includedFilename := ctx.template.set.resolveFilename(ctx.template, filename.String())
 
-		includedTpl, err2 := ctx.template.set.FromFile(includedFilename)
+		includedTpl, err2 := ctx.template.set.FromCache(includedFilename)
 		if err2 != nil {
 			// if this is ReadFile error, and "if_exists" flag is enabled
-			if node.ifExists && err2.(*Error).Sender == "fromfile" {
+			if node.ifExists && isMissingTemplateError(err2.(*Error)) {
 				return nil
 			}
 			return err2.(*Error)
 		}
-		err2 = includedTpl.ExecuteWriter(includeCtx, writer)
-		if err2 != nil {
-			return err2.(*Error)
-		}
-		return nil
+		tpl = includedTpl
 	}
-	// Template is already parsed with static filename
-	err := node.tpl.ExecuteWriter(includeCtx, writer)
-	if err != nil {
+
+	// Includes share the render state (and therefore the limits) of the includer
+	if err := ctx.enter(node.position); err != nil {
+		return err
+	}
+	defer ctx.leave()
+
+	if err := tpl.execute(ctx.state, includeCtx, writer); err != nil {
 		return err.(*Error)
 	}
 	return nil
 }
 
+func (node *tagIncludeNode) GetPositionToken() *Token {
+	return node.position
+}
+
 type tagIncludeEmptyNode struct{}
 
 func (node *tagIncludeEmptyNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	return nil
 }
 
+// isMissingTemplateError reports whether err was caused by a template which
+// couldn't be read. Sandbox violations are never treated as missing templates.
+func isMissingTemplateError(err *Error) bool {
+	if err.Sender != "fromfile" {
+		return false
+	}
+	_, isSandboxError := err.OrigError.(*SandboxError)
+	return !isSandboxError
+}
+
 func tagIncludeParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
 	includeNode := &tagIncludeNode{
+		position:  start,
 		withPairs: make(map[string]IEvaluator),
 	}
 
@@ -87,11 +113,13 @@ func tagIncludeParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *
 		includedFilename := doc.template.set.resolveFilename(doc.template, filenameToken.Val)
 
 		// Parse the parent
-		includeNode.filename = includedFilename
-		includedTpl, err := doc.template.set.FromFile(includedFilename)
+		includeNode.filename = doc.template.set.displayName(includedFilename)
+		includedTpl, err := doc.template.compileDependency(includedFilename)
 		if err != nil {
 			// if this is ReadFile error, and "if_exists" token presents we should create and empty node
-			if err.(*Error).Sender == "fromfile" && ifExists {
+			if isMissingTemplateError(err.(*Error)) && ifExists {
+				// Keep track of the missing template, it might be created later on
+				doc.template.dependencies = append(doc.template.dependencies, includedFilename)
 				return &tagIncludeEmptyNode{}, nil
 			}
 			return nil, err.(*Error).updateFromTokenIfNeeded(doc.template, filenameToken)
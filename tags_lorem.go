@@ -21,6 +21,10 @@ type tagLoremNode struct {
 }
 
 func (node *tagLoremNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
+	if err := ctx.addLoopIterations(node.count, node.position); err != nil {
+		return err
+	}
+
 	switch node.method {
 	case "b":
 		if node.random {
@@ -88,6 +92,10 @@ func (node *tagLoremNode) Execute(ctx *ExecutionContext, writer TemplateWriter)
 	return nil
 }
 
+func (node *tagLoremNode) GetPositionToken() *Token {
+	return node.position
+}
+
 func tagLoremParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
 	loremNode := &tagLoremNode{
 		position: start,
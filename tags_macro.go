@@ -15,18 +15,60 @@ type tagMacroNode struct {
 	wrapper *NodeWrapper
 }
 
-func (node *tagMacroNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
-	ctx.Private[node.name] = func(args ...*Value) *Value {
-		return node.call(ctx, args...)
+func (node *tagMacroNode) Children() []Node {
+	var children []Node
+	for _, name := range node.argsOrder {
+		children = appendNodes(children, node.args[name])
 	}
+	return appendNodes(children, node.wrapper)
+}
+
+// macroFunction is the type of macros within the context; they're called
+// with the positional and keyword arguments of the call.
+type macroFunction func(args []*Value, kwargs map[string]*Value) (*Value, *Error)
+
+func (node *tagMacroNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
+	ctx.Private[node.name] = node.function(ctx)
 
 	return nil
 }
 
-func (node *tagMacroNode) call(ctx *ExecutionContext, args ...*Value) *Value {
+func (node *tagMacroNode) GetPositionToken() *Token {
+	return node.position
+}
+
+// function returns the macro bound to the given context.
+func (node *tagMacroNode) function(ctx *ExecutionContext) macroFunction {
+	return func(args []*Value, kwargs map[string]*Value) (*Value, *Error) {
+		return node.call(ctx, args, kwargs)
+	}
+}
+
+func (node *tagMacroNode) call(ctx *ExecutionContext, args []*Value, kwargs map[string]*Value) (*Value, *Error) {
+	if len(args) > len(node.argsOrder) {
+		return nil, ctx.Error(fmt.Sprintf("Macro '%s' called with too many arguments (%d instead of %d).",
+			node.name, len(args), len(node.argsOrder)), nil)
+	}
+
 	argsCtx := make(Context)
+	for idx, argValue := range args {
+		argsCtx[node.argsOrder[idx]] = argValue.Interface()
+	}
+	for name, argValue := range kwargs {
+		// Every macro can be called with a caller (see tagCallNode)
+		if _, has := node.args[name]; !has && name != "caller" {
+			return nil, ctx.Error(fmt.Sprintf("Macro '%s' has no argument named '%s'.", node.name, name), nil)
+		}
+		if _, has := argsCtx[name]; has {
+			return nil, ctx.Error(fmt.Sprintf("Macro '%s' got multiple values for argument '%s'.", node.name, name), nil)
+		}
+		argsCtx[name] = argValue.Interface()
+	}
 
 	for k, v := range node.args {
+		if _, has := argsCtx[k]; has {
+			continue
+		}
 		if v == nil {
 			// User did not provided a default value
 			argsCtx[k] = nil
@@ -34,40 +76,32 @@ func (node *tagMacroNode) call(ctx *ExecutionContext, args ...*Value) *Value {
 			// Evaluate the default value
 			valueExpr, err := v.Evaluate(ctx)
 			if err != nil {
-				ctx.Logf(err.Error())
-				return AsSafeValue(err.Error())
+				return nil, err
 			}
 
 			argsCtx[k] = valueExpr
 		}
 	}
 
-	if len(args) > len(node.argsOrder) {
-		// Too many arguments, we're ignoring them and just logging into debug mode.
-		err := ctx.Error(fmt.Sprintf("Macro '%s' called with too many arguments (%d instead of %d).",
-			node.name, len(args), len(node.argsOrder)), nil).updateFromTokenIfNeeded(ctx.template, node.position)
-
-		ctx.Logf(err.Error()) // TODO: This is a workaround, because the error is not returned yet to the Execution()-methods
-		return AsSafeValue(err.Error())
-	}
-
 	// Make a context for the macro execution
 	macroCtx := NewChildExecutionContext(ctx)
 
 	// Register all arguments in the private context
 	macroCtx.Private.Update(argsCtx)
 
-	for idx, argValue := range args {
-		macroCtx.Private[node.argsOrder[idx]] = argValue.Interface()
+	// Recursive macro calls are limited by the set's MaxRecursionDepth
+	if err := ctx.enter(node.position); err != nil {
+		return nil, err
 	}
+	defer ctx.leave()
 
 	var b bytes.Buffer
 	err := node.wrapper.Execute(macroCtx, &b)
 	if err != nil {
-		return AsSafeValue(err.updateFromTokenIfNeeded(ctx.template, node.position).Error())
+		return nil, err
 	}
 
-	return AsSafeValue(b.String())
+	return AsSafeValue(b.String()), nil
 }
 
 func tagMacroParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
@@ -82,35 +116,8 @@ func tagMacroParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Er
 	}
 	macroNode.name = nameToken.Val
 
-	if arguments.MatchOne(TokenSymbol, "(") == nil {
-		return nil, arguments.Error("Expected '('.", nil)
-	}
-
-	for arguments.Match(TokenSymbol, ")") == nil {
-		argNameToken := arguments.MatchType(TokenIdentifier)
-		if argNameToken == nil {
-			return nil, arguments.Error("Expected argument name as identifier.", nil)
-		}
-		macroNode.argsOrder = append(macroNode.argsOrder, argNameToken.Val)
-
-		if arguments.Match(TokenSymbol, "=") != nil {
-			// Default expression follows
-			argDefaultExpr, err := arguments.ParseExpression()
-			if err != nil {
-				return nil, err
-			}
-			macroNode.args[argNameToken.Val] = argDefaultExpr
-		} else {
-			// No default expression
-			macroNode.args[argNameToken.Val] = nil
-		}
-
-		if arguments.Match(TokenSymbol, ")") != nil {
-			break
-		}
-		if arguments.Match(TokenSymbol, ",") == nil {
-			return nil, arguments.Error("Expected ',' or ')'.", nil)
-		}
+	if err := parseMacroArguments(arguments, macroNode); err != nil {
+		return nil, err
 	}
 
 	if arguments.Match(TokenKeyword, "export") != nil {
@@ -121,8 +128,11 @@ func tagMacroParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Er
 		return nil, arguments.Error("Malformed macro-tag.", nil)
 	}
 
-	// Body wrapping
+	// Body wrapping; a macro body is not part of any surrounding loop
+	loopDepth := doc.loopDepth
+	doc.loopDepth = 0
 	wrapper, endargs, err := doc.WrapUntilTag("endmacro")
+	doc.loopDepth = loopDepth
 	if err != nil {
 		return nil, err
 	}
@@ -144,6 +154,43 @@ func tagMacroParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Er
 	return macroNode, nil
 }
 
+// parseMacroArguments parses the argument list of a macro (or of a
+// call-block, see tagCallNode) including the parentheses.
+func parseMacroArguments(arguments *Parser, node *tagMacroNode) *Error {
+	if arguments.MatchOne(TokenSymbol, "(") == nil {
+		return arguments.Error("Expected '('.", nil)
+	}
+
+	for arguments.Match(TokenSymbol, ")") == nil {
+		argNameToken := arguments.MatchType(TokenIdentifier)
+		if argNameToken == nil {
+			return arguments.Error("Expected argument name as identifier.", nil)
+		}
+		node.argsOrder = append(node.argsOrder, argNameToken.Val)
+
+		if arguments.Match(TokenSymbol, "=") != nil {
+			// Default expression follows
+			argDefaultExpr, err := arguments.ParseExpression()
+			if err != nil {
+				return err
+			}
+			node.args[argNameToken.Val] = argDefaultExpr
+		} else {
+			// No default expression
+			node.args[argNameToken.Val] = nil
+		}
+
+		if arguments.Match(TokenSymbol, ")") != nil {
+			break
+		}
+		if arguments.Match(TokenSymbol, ",") == nil {
+			return arguments.Error("Expected ',' or ')'.", nil)
+		}
+	}
+
+	return nil
+}
+
 func init() {
 	RegisterTag("macro", tagMacroParser)
 }
@@ -1,23 +1,82 @@
 package pongo2
 
+import (
+	"bytes"
+	"fmt"
+)
+
 type tagSetNode struct {
+	position   *Token
 	name       string
+	attribute  string // only for namespaces: {% set ns.attribute = ... %}
 	expression IEvaluator
+	wrapper    *NodeWrapper // only for block captures: {% set name %}...{% endset %}
+}
+
+func (node *tagSetNode) Children() []Node {
+	return appendNodes(nil, node.expression, node.wrapper)
+}
+
+// namespace is the mutable object returned by namespace() within templates.
+// Assignments to its attributes (using the set-tag) survive the end of child
+// contexts, e. g. they're still available after a for-loop has finished.
+type namespace map[string]*Value
+
+func newNamespace(attributes map[string]*Value) namespace {
+	return namespace(attributes)
 }
 
 func (node *tagSetNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
-	// Evaluate expression
-	value, err := node.expression.Evaluate(ctx)
-	if err != nil {
-		return err
+	var (
+		value   *Value
+		loopErr *Error // break or continue within a block capture
+	)
+	if node.wrapper != nil {
+		// Capture the rendered block
+		var b bytes.Buffer
+		loopErr = node.wrapper.Execute(ctx, &b)
+		if loopErr != nil && !isLoopControl(loopErr) {
+			return loopErr
+		}
+		value = AsSafeValue(b.String())
+	} else {
+		// Evaluate expression
+		var err *Error
+		value, err = node.expression.Evaluate(ctx)
+		if err != nil {
+			return err
+		}
+	}
+
+	if node.attribute == "" {
+		ctx.Private[node.name] = value
+		return loopErr
 	}
 
-	ctx.Private[node.name] = value
-	return nil
+	obj, has := ctx.Private[node.name]
+	if !has {
+		obj = ctx.Public[node.name]
+	}
+	if v, isValue := obj.(*Value); isValue {
+		obj = v.Interface()
+	}
+	ns, isNamespace := obj.(namespace)
+	if !isNamespace {
+		return ctx.Error(fmt.Sprintf("Cannot set attribute '%s' of '%s' (it is not a namespace).",
+			node.attribute, node.name), node.position)
+	}
+	ns[node.attribute] = value
+	return loopErr
+}
+
+func (node *tagSetNode) GetPositionToken() *Token {
+	return node.position
 }
 
 func tagSetParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
-	node := &tagSetNode{}
+	node := &tagSetNode{
+		position: start,
+	}
 
 	// Parse variable name
 	typeToken := arguments.MatchType(TokenIdentifier)
@@ -26,6 +85,30 @@ func tagSetParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Erro
 	}
 	node.name = typeToken.Val
 
+	if arguments.Match(TokenSymbol, ".") != nil {
+		// Namespace attribute
+		attributeToken := arguments.MatchType(TokenIdentifier)
+		if attributeToken == nil {
+			return nil, arguments.Error("Expected an attribute name (identifier).", nil)
+		}
+		node.attribute = attributeToken.Val
+	}
+
+	if arguments.Remaining() == 0 {
+		// Block capture
+		wrapper, endargs, err := doc.WrapUntilTag("endset")
+		if err != nil {
+			return nil, err
+		}
+		node.wrapper = wrapper
+
+		if endargs.Count() > 0 {
+			return nil, endargs.Error("Arguments not allowed here.", nil)
+		}
+
+		return node, nil
+	}
+
 	if arguments.Match(TokenSymbol, "=") == nil {
 		return nil, arguments.Error("Expected '='.", nil)
 	}
@@ -9,14 +9,18 @@ type tagSpacelessNode struct {
 	wrapper *NodeWrapper
 }
 
+func (node *tagSpacelessNode) Children() []Node {
+	return appendNodes(nil, node.wrapper)
+}
+
 var tagSpacelessRegexp = regexp.MustCompile(`(?U:(<.*>))([\t\n\v\f\r ]+)(?U:(<.*>))`)
 
 func (node *tagSpacelessNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	b := bytes.NewBuffer(make([]byte, 0, 1024)) // 1 KiB
 
-	err := node.wrapper.Execute(ctx, b)
-	if err != nil {
-		return err
+	loopErr := node.wrapper.Execute(ctx, b)
+	if loopErr != nil && !isLoopControl(loopErr) {
+		return loopErr
 	}
 
 	s := b.String()
@@ -30,7 +34,7 @@ func (node *tagSpacelessNode) Execute(ctx *ExecutionContext, writer TemplateWrit
 
 	writer.WriteString(s)
 
-	return nil
+	return loopErr // break or continue, if any
 }
 
 func tagSpacelessParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Error) {
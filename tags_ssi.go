@@ -10,6 +10,10 @@ type tagSSINode struct {
 	template *Template
 }
 
+func (node *tagSSINode) Filename() string {
+	return node.filename
+}
+
 func (node *tagSSINode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	if node.template != nil {
 		// Execute the template within the current context
@@ -17,7 +21,7 @@ func (node *tagSSINode) Execute(ctx *ExecutionContext, writer TemplateWriter) *E
 		includeCtx.Update(ctx.Public)
 		includeCtx.Update(ctx.Private)
 
-		err := node.template.execute(includeCtx, writer)
+		err := node.template.execute(ctx.state, includeCtx, writer)
 		if err != nil {
 			return err.(*Error)
 		}
@@ -36,14 +40,25 @@ func tagSSIParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Erro
 
 		if arguments.Match(TokenIdentifier, "parsed") != nil {
 			// parsed
-			temporaryTpl, err := doc.template.set.FromFile(doc.template.set.resolveFilename(doc.template, fileToken.Val))
+			temporaryTpl, err := doc.template.compileDependency(doc.template.set.resolveFilename(doc.template, fileToken.Val))
 			if err != nil {
 				return nil, err.(*Error).updateFromTokenIfNeeded(doc.template, fileToken)
 			}
 			SSINode.template = temporaryTpl
 		} else {
 			// plaintext
-			buf, err := ioutil.ReadFile(doc.template.set.resolveFilename(doc.template, fileToken.Val))
+			filename := doc.template.set.resolveFilename(doc.template, fileToken.Val)
+			if stamp, stamped := doc.template.set.stamp(filename); stamped {
+				doc.template.addStamp(filename, stamp)
+			}
+			fd, err := doc.template.set.loader.Get(filename)
+			if err != nil {
+				return nil, (&Error{
+					Sender:    "tag:ssi",
+					OrigError: err,
+				}).updateFromTokenIfNeeded(doc.template, fileToken)
+			}
+			buf, err := ioutil.ReadAll(fd)
 			if err != nil {
 				return nil, (&Error{
 					Sender:    "tag:ssi",
@@ -51,6 +66,7 @@ func tagSSIParser(doc *Parser, start *Token, arguments *Parser) (INodeTag, *Erro
 				}).updateFromTokenIfNeeded(doc.template, fileToken)
 			}
 			SSINode.content = string(buf)
+			doc.template.dependencies = append(doc.template.dependencies, filename)
 		}
 	} else {
 		return nil, arguments.Error("First argument must be a string.", nil)
@@ -12,6 +12,10 @@ type tagWidthratioNode struct {
 	ctxName      string
 }
 
+func (node *tagWidthratioNode) Children() []Node {
+	return appendNodes(nil, node.current, node.max, node.width)
+}
+
 func (node *tagWidthratioNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	current, err := node.current.Evaluate(ctx)
 	if err != nil {
@@ -5,6 +5,10 @@ type tagWithNode struct {
 	wrapper   *NodeWrapper
 }
 
+func (node *tagWithNode) Children() []Node {
+	return appendNodes(appendNodeMap(nil, node.withPairs), node.wrapper)
+}
+
 func (node *tagWithNode) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
 	//new context for block
 	withctx := NewChildExecutionContext(ctx)
@@ -2,7 +2,10 @@ package pongo2
 
 import (
 	"bytes"
+	"context"
 	"io"
+	"sort"
+	"time"
 
 	"github.com/juju/errors"
 )
@@ -29,7 +32,8 @@ type Template struct {
 
 	// Input
 	isTplString bool
-	name        string
+	name        string // as resolved by the set's loader
+	displayName string // shown to users (see TemplateSet.displayName)
 	tpl         string
 	size        int
 
@@ -39,20 +43,31 @@ type Template struct {
 
 	// first come, first serve (it's important to not override existing entries in here)
 	level          int
-	parent         *Template
-	child          *Template
+	extends        *tagExtendsNode // the template's parent is resolved per execution
 	blocks         map[string]*NodeWrapper
 	exportedMacros map[string]*tagMacroNode
 
+	// The template which is compiling this one (because it includes, imports
+	// or extends it); used to detect recursive templates
+	includer *Template
+
+	// Resolved names of all templates and files which have been loaded
+	// while compiling this template (parents, static includes, imports, SSIs)
+	dependencies []string
+
+	// Modification times of this template and its dependencies, taken
+	// before loading them (only if the set's AutoReload is enabled)
+	stamps map[string]time.Time
+
 	// Output
 	root *nodeDocument
 }
 
 func newTemplateString(set *TemplateSet, tpl []byte) (*Template, error) {
-	return newTemplate(set, "<string>", true, tpl)
+	return newTemplate(set, "<string>", true, tpl, nil)
 }
 
-func newTemplate(set *TemplateSet, name string, isTplString bool, tpl []byte) (*Template, error) {
+func newTemplate(set *TemplateSet, name string, isTplString bool, tpl []byte, includer *Template) (*Template, error) {
 	strTpl := string(tpl)
 
 	// Create the template
@@ -60,14 +75,16 @@ func newTemplate(set *TemplateSet, name string, isTplString bool, tpl []byte) (*
 		set:            set,
 		isTplString:    isTplString,
 		name:           name,
+		displayName:    set.displayName(name),
 		tpl:            strTpl,
 		size:           len(strTpl),
 		blocks:         make(map[string]*NodeWrapper),
 		exportedMacros: make(map[string]*tagMacroNode),
+		includer:       includer,
 	}
 
 	// Tokenize it
-	tokens, err := lex(name, strTpl)
+	tokens, err := lex(t.displayName, strTpl, set)
 	if err != nil {
 		return nil, err
 	}
@@ -87,19 +104,69 @@ func newTemplate(set *TemplateSet, name string, isTplString bool, tpl []byte) (*
 	return t, nil
 }
 
-func (tpl *Template) execute(context Context, writer TemplateWriter) error {
-	// Determine the parent to be executed (for template inheritance)
-	parent := tpl
-	for parent.parent != nil {
-		parent = parent.parent
+// compileDependency compiles the template with the given (already resolved)
+// filename and records it and all of its own dependencies as dependencies of tpl.
+func (tpl *Template) compileDependency(filename string) (*Template, error) {
+	dep, err := tpl.set.fromFile(filename, tpl)
+	if err != nil {
+		return nil, err
+	}
+	tpl.dependencies = append(tpl.dependencies, filename)
+	tpl.dependencies = append(tpl.dependencies, dep.dependencies...)
+	for name, stamp := range dep.stamps {
+		tpl.addStamp(name, stamp)
+	}
+	return dep, nil
+}
+
+// addStamp records the modification time of the template or file with the
+// given name; it must have been taken before the template has been loaded.
+func (tpl *Template) addStamp(name string, stamp time.Time) {
+	if tpl.stamps == nil {
+		tpl.stamps = make(map[string]time.Time)
 	}
+	tpl.stamps[name] = stamp
+}
 
+// render creates a new render state (respecting the set's limits) and
+// executes the template (or only the given block if block is not empty).
+func (tpl *Template) render(goCtx context.Context, data Context, writer TemplateWriter, block string) error {
+	state := newRenderState(tpl.set, goCtx)
+	if tpl.set.MaxOutputBytes > 0 {
+		writer = &limitedTemplateWriter{w: writer, state: state}
+	}
+
+	if err := tpl.executeBlock(state, data, writer, block); err != nil {
+		return err
+	}
+
+	// The last node might have exceeded the output limit
+	if state.err != nil {
+		if state.err.Template == nil {
+			state.err.Template = tpl
+			state.err.Filename = tpl.displayName
+		}
+		return state.err
+	}
+
+	return nil
+}
+
+// execute executes the template within the given render state; it's
+// used to execute included templates within the state of their includer.
+func (tpl *Template) execute(state *renderState, data Context, writer TemplateWriter) error {
+	return tpl.executeBlock(state, data, writer, "")
+}
+
+// executeBlock works like execute, but only executes the block with the
+// given name (resolving the template's inheritance) if block is not empty.
+func (tpl *Template) executeBlock(state *renderState, data Context, writer TemplateWriter, block string) error {
 	// Create context if none is given
 	newContext := make(Context)
 	newContext.Update(tpl.set.Globals)
 
-	if context != nil {
-		newContext.Update(context)
+	if data != nil {
+		newContext.Update(data)
 
 		if len(newContext) > 0 {
 			// Check for context name syntax
@@ -113,7 +180,7 @@ func (tpl *Template) execute(context Context, writer TemplateWriter) error {
 				_, has := tpl.exportedMacros[k]
 				if has {
 					return &Error{
-						Filename:  tpl.name,
+						Filename:  tpl.displayName,
 						Sender:    "execution",
 						OrigError: errors.Errorf("context key name '%s' clashes with macro '%s'", k, k),
 					}
@@ -123,7 +190,34 @@ func (tpl *Template) execute(context Context, writer TemplateWriter) error {
 	}
 
 	// Create operational context
-	ctx := newExecutionContext(parent, newContext)
+	ctx := newExecutionContext(tpl, newContext)
+	ctx.state = state
+
+	// Determine the parent to be executed (for template inheritance)
+	chain, err := tpl.inheritanceChain(ctx)
+	if err != nil {
+		return err
+	}
+	parent := chain[0]
+	ctx.template = parent
+	ctx.inheritance = chain
+
+	if block != "" {
+		// Run the selected block only
+		blockNode := &tagBlockNode{name: block}
+		if len(blockNode.getBlockWrappers(ctx)) == 0 {
+			return &Error{
+				Template:  tpl,
+				Filename:  tpl.displayName,
+				Sender:    "execution",
+				OrigError: errors.Errorf("block '%s' does not exist", block),
+			}
+		}
+		if err := blockNode.Execute(ctx, writer); err != nil {
+			return err
+		}
+		return nil
+	}
 
 	// Run the selected document
 	if err := parent.root.Execute(ctx, writer); err != nil {
@@ -133,25 +227,22 @@ func (tpl *Template) execute(context Context, writer TemplateWriter) error {
 	return nil
 }
 
-func (tpl *Template) newTemplateWriterAndExecute(context Context, writer io.Writer) error {
-	return tpl.execute(context, &templateWriter{w: writer})
+func (tpl *Template) newTemplateWriterAndExecute(goCtx context.Context, data Context, writer io.Writer) error {
+	return tpl.render(goCtx, data, &templateWriter{w: writer}, "")
 }
 
-func (tpl *Template) newBufferAndExecute(context Context) (*bytes.Buffer, error) {
+func (tpl *Template) newBufferAndExecute(goCtx context.Context, data Context) (*bytes.Buffer, error) {
 	// Create output buffer
 	// We assume that the rendered template will be 30% larger
 	buffer := bytes.NewBuffer(make([]byte, 0, int(float64(tpl.size)*1.3)))
-	if err := tpl.execute(context, buffer); err != nil {
+	if err := tpl.render(goCtx, data, buffer, ""); err != nil {
 		return nil, err
 	}
 	return buffer, nil
 }
 
-// Executes the template with the given context and writes to writer (io.Writer)
-// on success. Context can be nil. Nothing is written on error; instead the error
-// is being returned.
-func (tpl *Template) ExecuteWriter(context Context, writer io.Writer) error {
-	buf, err := tpl.newBufferAndExecute(context)
+func (tpl *Template) executeWriter(goCtx context.Context, data Context, writer io.Writer) error {
+	buf, err := tpl.newBufferAndExecute(goCtx, data)
 	if err != nil {
 		return err
 	}
@@ -162,19 +253,34 @@ func (tpl *Template) ExecuteWriter(context Context, writer io.Writer) error {
 	return nil
 }
 
+// Executes the template with the given context and writes to writer (io.Writer)
+// on success. Context can be nil. Nothing is written on error; instead the error
+// is being returned.
+func (tpl *Template) ExecuteWriter(context Context, writer io.Writer) error {
+	return tpl.executeWriter(nil, context, writer)
+}
+
 // Same as ExecuteWriter. The only difference between both functions is that
 // this function might already have written parts of the generated template in the
 // case of an execution error because there's no intermediate buffer involved for
 // performance reasons. This is handy if you need high performance template
 // generation or if you want to manage your own pool of buffers.
 func (tpl *Template) ExecuteWriterUnbuffered(context Context, writer io.Writer) error {
-	return tpl.newTemplateWriterAndExecute(context, writer)
+	return tpl.newTemplateWriterAndExecute(nil, context, writer)
+}
+
+// ExecuteWriterContext works like ExecuteWriter, but stops the execution as
+// soon as ctx is canceled or its deadline exceeded. The returned *Error's OrigError
+// will be ctx.Err() in this case. ctx is available to tags and functions through
+// ExecutionContext.Context().
+func (tpl *Template) ExecuteWriterContext(ctx context.Context, data Context, writer io.Writer) error {
+	return tpl.executeWriter(ctx, data, writer)
 }
 
 // Executes the template and returns the rendered template as a []byte
 func (tpl *Template) ExecuteBytes(context Context) ([]byte, error) {
 	// Execute template
-	buffer, err := tpl.newBufferAndExecute(context)
+	buffer, err := tpl.newBufferAndExecute(nil, context)
 	if err != nil {
 		return nil, err
 	}
@@ -184,7 +290,7 @@ func (tpl *Template) ExecuteBytes(context Context) ([]byte, error) {
 // Executes the template and returns the rendered template as a string
 func (tpl *Template) Execute(context Context) (string, error) {
 	// Execute template
-	buffer, err := tpl.newBufferAndExecute(context)
+	buffer, err := tpl.newBufferAndExecute(nil, context)
 	if err != nil {
 		return "", err
 	}
@@ -192,3 +298,72 @@ func (tpl *Template) Execute(context Context) (string, error) {
 	return buffer.String(), nil
 
 }
+
+// ExecuteContext works like Execute, but stops the execution as soon as ctx
+// is canceled or its deadline exceeded (see ExecuteWriterContext).
+func (tpl *Template) ExecuteContext(ctx context.Context, data Context) (string, error) {
+	buffer, err := tpl.newBufferAndExecute(ctx, data)
+	if err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// ExecuteBlock executes only the block with the given name and returns it
+// rendered as a string (e. g. to update parts of a page). The block is resolved
+// through the template's inheritance chain like the block-tag does, so
+// overridden blocks and block.Super work as usual.
+func (tpl *Template) ExecuteBlock(name string, context Context) (string, error) {
+	return tpl.ExecuteBlockContext(nil, name, context)
+}
+
+// ExecuteBlockWriter works like ExecuteBlock, but writes the rendered block
+// to writer. Nothing is written on error.
+func (tpl *Template) ExecuteBlockWriter(name string, context Context, writer io.Writer) error {
+	return tpl.ExecuteBlockWriterContext(nil, name, context, writer)
+}
+
+// ExecuteBlockContext works like ExecuteBlock, but stops the execution as
+// soon as ctx is canceled or its deadline exceeded (see ExecuteWriterContext).
+func (tpl *Template) ExecuteBlockContext(ctx context.Context, name string, data Context) (string, error) {
+	var buffer bytes.Buffer
+	if err := tpl.render(ctx, data, &buffer, name); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
+
+// ExecuteBlockWriterContext works like ExecuteBlockWriter, but stops the
+// execution as soon as ctx is canceled or its deadline exceeded (see
+// ExecuteWriterContext).
+func (tpl *Template) ExecuteBlockWriterContext(ctx context.Context, name string, data Context, writer io.Writer) error {
+	var buffer bytes.Buffer
+	if err := tpl.render(ctx, data, &buffer, name); err != nil {
+		return err
+	}
+	_, err := buffer.WriteTo(writer)
+	return err
+}
+
+// Blocks returns the sorted names of all blocks defined by the template and
+// by the templates it extends. Parents of dynamic extends-tags
+// ({% extends layout %}) are only known at execution time and therefore
+// not taken into account.
+func (tpl *Template) Blocks() []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(tpl.blocks))
+	for t := tpl; t != nil; {
+		for name := range t.blocks {
+			if !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+		if t.extends == nil {
+			break
+		}
+		t = t.extends.parent
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,292 @@
+package pongo2
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// TemplateCacheStats contains statistics about a TemplateSet's template cache.
+type TemplateCacheStats struct {
+	Size      int    // Amount of currently cached templates
+	Hits      uint64 // Calls to FromCache() served from the cache
+	Misses    uint64 // Calls to FromCache() which required a compilation
+	Waits     uint64 // Calls to FromCache() which waited for a concurrent compilation of the same template
+	Evictions uint64 // Templates evicted due to TemplateSet.CacheSize
+}
+
+// templateCache is a LRU cache for compiled templates (see TemplateSet.FromCache).
+type templateCache struct {
+	mu      sync.Mutex
+	entries map[string]*list.Element // name -> element (value: *templateCacheEntry)
+	lru     *list.List               // most recently used entry at the front
+	calls   map[string]*templateCacheCall
+	stats   TemplateCacheStats
+
+	// generation is incremented on every invalidation; templates which
+	// have been compiled before an invalidation won't be cached.
+	generation uint64
+}
+
+type templateCacheEntry struct {
+	name string
+	tpl  *Template
+
+	// Modification times of the template and its dependencies (AutoReload only)
+	stamps    map[string]time.Time
+	lastCheck time.Time
+}
+
+// templateCacheCall represents an in-flight compilation of a template. Concurrent
+// callers requesting the same template wait for it instead of compiling it again.
+type templateCacheCall struct {
+	wg  sync.WaitGroup
+	tpl *Template
+	err error
+}
+
+func newTemplateCache() *templateCache {
+	return &templateCache{
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		calls:   make(map[string]*templateCacheCall),
+	}
+}
+
+// FromCache is a convenient method to cache templates. It is thread-safe
+// and will only compile the template associated with a filename once.
+// If TemplateSet.Debug is true (for example during development phase),
+// FromCache() will not cache the template and instead recompile it on any
+// call (to make changes to a template live instantaneously).
+// See TemplateSet.AutoReload for recompiling changed templates only and
+// TemplateSet.CacheSize for limiting the cache's size.
+func (set *TemplateSet) FromCache(filename string) (*Template, error) {
+	if set.Debug {
+		// Recompile on any request
+		return set.FromFile(filename)
+	}
+	// Cache the template
+	cleanedFilename := set.resolveFilename(nil, filename)
+	cache := set.templateCache
+
+	cache.mu.Lock()
+	if elem, has := cache.entries[cleanedFilename]; has {
+		entry := elem.Value.(*templateCacheEntry)
+		if set.checkDue(entry) {
+			// Check the loader without holding the lock, so other
+			// templates can be served in the meantime
+			cache.mu.Unlock()
+			outdated := set.isOutdated(entry)
+			cache.mu.Lock()
+			if outdated {
+				cache.removeEntry(entry)
+			}
+		}
+		// The entry might have been replaced or evicted in the meantime
+		if elem, has := cache.entries[cleanedFilename]; has {
+			// Cache hit
+			cache.lru.MoveToFront(elem)
+			cache.stats.Hits++
+			cache.mu.Unlock()
+			return elem.Value.(*templateCacheEntry).tpl, nil
+		}
+	}
+
+	// Cache miss; someone else might be compiling the template already
+	if call, has := cache.calls[cleanedFilename]; has {
+		cache.stats.Waits++
+		cache.mu.Unlock()
+		call.wg.Wait()
+		return call.tpl, call.err
+	}
+	cache.stats.Misses++
+	call := &templateCacheCall{}
+	call.wg.Add(1)
+	cache.calls[cleanedFilename] = call
+	generation := cache.generation
+	cache.mu.Unlock()
+
+	// Compile the template without holding the lock, so other
+	// templates can be compiled (or served) in the meantime
+	call.tpl, call.err = set.FromFile(cleanedFilename)
+	var entry *templateCacheEntry
+	if call.err == nil {
+		entry = set.newCacheEntry(cleanedFilename, call.tpl)
+	}
+
+	cache.mu.Lock()
+	delete(cache.calls, cleanedFilename)
+	if entry != nil && generation == cache.generation {
+		cache.entries[cleanedFilename] = cache.lru.PushFront(entry)
+		for set.CacheSize > 0 && cache.lru.Len() > set.CacheSize {
+			cache.remove(cache.lru.Back())
+			cache.stats.Evictions++
+		}
+	}
+	cache.mu.Unlock()
+	call.wg.Done()
+
+	return call.tpl, call.err
+}
+
+// Invalidate removes the template with the given name from the cache, together
+// with all cached templates depending on it (e. g. by extending or including it).
+func (set *TemplateSet) Invalidate(filename string) {
+	name := set.resolveFilename(nil, filename)
+	set.invalidate(func(path string) bool {
+		return path == name
+	})
+}
+
+// InvalidateAll removes all templates from the cache.
+func (set *TemplateSet) InvalidateAll() {
+	set.invalidate(func(path string) bool {
+		return true
+	})
+}
+
+// CachedTemplates returns the names of all cached templates, the most
+// recently used template first. A template extending a template with its own
+// name (see ChainedLoader) is listed once for each of them.
+func (set *TemplateSet) CachedTemplates() []string {
+	cache := set.templateCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	names := make([]string, 0, cache.lru.Len())
+	for elem := cache.lru.Front(); elem != nil; elem = elem.Next() {
+		names = append(names, set.displayName(elem.Value.(*templateCacheEntry).name))
+	}
+	return names
+}
+
+// CacheStats returns statistics about the template cache used by FromCache().
+func (set *TemplateSet) CacheStats() TemplateCacheStats {
+	cache := set.templateCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	stats := cache.stats
+	stats.Size = cache.lru.Len()
+	return stats
+}
+
+// invalidate removes all templates from the cache whose name or one of
+// their dependencies' names matches.
+func (set *TemplateSet) invalidate(match func(path string) bool) {
+	cache := set.templateCache
+	cache.mu.Lock()
+	defer cache.mu.Unlock()
+
+	cache.generation++
+
+	var next *list.Element
+	for elem := cache.lru.Front(); elem != nil; elem = next {
+		next = elem.Next()
+		entry := elem.Value.(*templateCacheEntry)
+		if match(entry.name) {
+			cache.remove(elem)
+			continue
+		}
+		for _, dep := range entry.tpl.dependencies {
+			if match(dep) {
+				cache.remove(elem)
+				break
+			}
+		}
+	}
+}
+
+// remove removes the element from the cache. The caller must hold the lock.
+func (cache *templateCache) remove(elem *list.Element) {
+	cache.lru.Remove(elem)
+	delete(cache.entries, elem.Value.(*templateCacheEntry).name)
+}
+
+// removeEntry removes the entry from the cache unless it has been removed
+// or replaced already. The caller must hold the lock.
+func (cache *templateCache) removeEntry(entry *templateCacheEntry) {
+	if elem, has := cache.entries[entry.name]; has && elem.Value.(*templateCacheEntry) == entry {
+		cache.remove(elem)
+	}
+}
+
+func (set *TemplateSet) newCacheEntry(name string, tpl *Template) *templateCacheEntry {
+	entry := &templateCacheEntry{
+		name: name,
+		tpl:  tpl,
+	}
+
+	if _, ok := set.loader.(StatLoader); !set.AutoReload || !ok {
+		return entry
+	}
+
+	entry.lastCheck = time.Now()
+	entry.stamps = make(map[string]time.Time, len(tpl.dependencies)+1)
+	for _, name := range append([]string{tpl.name}, tpl.dependencies...) {
+		// The stamps have been taken while loading the templates; missing
+		// templates (e. g. from an include with if_exists) have a zero time.
+		entry.stamps[name] = tpl.stamps[name]
+	}
+	return entry
+}
+
+// stamp returns the modification time of the template or file with the given
+// name if AutoReload is enabled (a zero time if it doesn't exist).
+func (set *TemplateSet) stamp(name string) (time.Time, bool) {
+	statLoader, ok := set.loader.(StatLoader)
+	if !set.AutoReload || !ok {
+		return time.Time{}, false
+	}
+	stamp, _ := statLoader.Stat(set.resolveFilename(nil, name))
+	return stamp, true
+}
+
+// checkDue reports whether the cached template has to be checked for
+// changes (see isOutdated). The caller must hold the cache's lock.
+func (set *TemplateSet) checkDue(entry *templateCacheEntry) bool {
+	if _, ok := set.loader.(StatLoader); !set.AutoReload || !ok {
+		return false
+	}
+	if entry.stamps == nil {
+		// Cached before AutoReload was enabled
+		return true
+	}
+
+	now := time.Now()
+	if now.Sub(entry.lastCheck) < set.AutoReloadInterval {
+		return false
+	}
+	entry.lastCheck = now
+	return true
+}
+
+// isOutdated checks whether the cached template or one of its dependencies has
+// been changed since compilation. It accesses the loader, so the caller must
+// not hold the cache's lock (the entry's stamps are never modified).
+func (set *TemplateSet) isOutdated(entry *templateCacheEntry) bool {
+	statLoader, ok := set.loader.(StatLoader)
+	if !ok {
+		return false
+	}
+	if entry.stamps == nil {
+		return true
+	}
+
+	for name, stamp := range entry.stamps {
+		current, err := statLoader.Stat(set.resolveFilename(nil, name))
+		if err != nil {
+			if !stamp.IsZero() {
+				// Template has been removed
+				set.logf("Template '%s' has been removed, recompiling.", set.displayName(name))
+				return true
+			}
+			continue
+		}
+		if !current.Equal(stamp) {
+			set.logf("Template '%s' has been changed, recompiling.", set.displayName(name))
+			return true
+		}
+	}
+	return false
+}
@@ -2,11 +2,18 @@ package pongo2
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"log"
 	"os"
+	"path"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/juju/errors"
 )
@@ -78,6 +85,15 @@ func (fs *LocalFilesystemLoader) Get(path string) (io.Reader, error) {
 	return bytes.NewReader(buf), nil
 }
 
+// Stat returns the modification time of the file at path.
+func (fs *LocalFilesystemLoader) Stat(path string) (time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
 // Abs resolves a filename relative to the base directory. Absolute paths are allowed.
 // When there's no base dir set, the absolute path to the filename
 // will be calculated based on either the provided base directory (which
@@ -106,52 +122,489 @@ func (fs *LocalFilesystemLoader) Abs(base, name string) string {
 	return filepath.Join(fs.baseDir, name)
 }
 
-// SandboxedFilesystemLoader is still WIP.
+// FSLoader represents a loader which reads templates from any io/fs.FS,
+// for example an embed.FS created using the //go:embed directive. Template
+// names are slash-separated paths relative to the file system's root.
+type FSLoader struct {
+	fs fs.FS
+}
+
+// NewFSLoader creates a new FSLoader which loads all templates from the
+// given file system. Use fs.Sub if your templates live in a subdirectory
+// of the file system.
+func NewFSLoader(fsys fs.FS) *FSLoader {
+	return &FSLoader{
+		fs: fsys,
+	}
+}
+
+// Get opens the file at the given path within the file system and returns its content.
+func (l *FSLoader) Get(path string) (io.Reader, error) {
+	buf, err := fs.ReadFile(l.fs, path)
+	if err != nil {
+		return nil, err
+	}
+	return bytes.NewReader(buf), nil
+}
+
+// Stat returns the modification time of the file at path.
+func (l *FSLoader) Stat(path string) (time.Time, error) {
+	fi, err := fs.Stat(l.fs, path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fi.ModTime(), nil
+}
+
+// Abs resolves a filename relative to the including template's path (given
+// in base). Names starting with a slash are resolved relative to the file
+// system's root. The returned path is always unrooted as required by io/fs.
+func (l *FSLoader) Abs(base, name string) string {
+	return slashAbs(base, name)
+}
+
+// MemoryLoader is a TemplateLoader which holds all templates in memory.
+// It's useful for tests and for templates stored somewhere else than in
+// files (for example in a database). Templates can be added, changed and
+// removed at any time using Set and Delete; compiled templates depending
+// on a changed template are removed from the cache of every TemplateSet
+// using this loader. Template names are resolved like with the FSLoader.
+type MemoryLoader struct {
+	mu           sync.RWMutex
+	templates    map[string][]byte
+	modified     map[string]time.Time
+	invalidators map[int]cacheInvalidator // registration id -> invalidator
+	nextID       int
+}
+
+// NewMemoryLoader creates a new MemoryLoader providing the given templates (name -> content).
+func NewMemoryLoader(templates map[string]string) *MemoryLoader {
+	l := &MemoryLoader{
+		templates: make(map[string][]byte, len(templates)),
+		modified:  make(map[string]time.Time, len(templates)),
+	}
+	now := time.Now()
+	for name, content := range templates {
+		l.templates[l.Abs("", name)] = []byte(content)
+		l.modified[l.Abs("", name)] = now
+	}
+	return l
+}
+
+// NewMemoryLoaderFromBytes works like NewMemoryLoader, but takes the templates' contents as bytes.
+func NewMemoryLoaderFromBytes(templates map[string][]byte) *MemoryLoader {
+	l := &MemoryLoader{
+		templates: make(map[string][]byte, len(templates)),
+		modified:  make(map[string]time.Time, len(templates)),
+	}
+	now := time.Now()
+	for name, content := range templates {
+		l.templates[l.Abs("", name)] = append([]byte(nil), content...)
+		l.modified[l.Abs("", name)] = now
+	}
+	return l
+}
+
+// Set adds or replaces the template with the given name. It is thread-safe.
+func (l *MemoryLoader) Set(name, content string) {
+	l.SetBytes(name, []byte(content))
+}
+
+// SetBytes works like Set, but takes the template's content as bytes.
+func (l *MemoryLoader) SetBytes(name string, content []byte) {
+	name = l.Abs("", name)
+
+	l.mu.Lock()
+	l.templates[name] = append([]byte(nil), content...)
+	l.modified[name] = time.Now()
+	l.mu.Unlock()
+
+	l.notify(name)
+}
+
+// Delete removes the template with the given name. It is thread-safe.
+func (l *MemoryLoader) Delete(name string) {
+	name = l.Abs("", name)
+
+	l.mu.Lock()
+	delete(l.templates, name)
+	delete(l.modified, name)
+	l.mu.Unlock()
+
+	l.notify(name)
+}
+
+// notify evicts all cached templates depending on name. The loader's lock must
+// not be held here, because the sets might be loading templates at the same time.
+func (l *MemoryLoader) notify(name string) {
+	l.mu.RLock()
+	invalidators := make([]cacheInvalidator, 0, len(l.invalidators))
+	for _, inv := range l.invalidators {
+		invalidators = append(invalidators, inv)
+	}
+	l.mu.RUnlock()
+
+	for _, inv := range invalidators {
+		inv.invalidate(func(path string) bool {
+			return path == name
+		})
+	}
+}
+
+func (l *MemoryLoader) addInvalidator(inv cacheInvalidator) func() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.invalidators == nil {
+		l.invalidators = make(map[int]cacheInvalidator)
+	}
+	id := l.nextID
+	l.nextID++
+	l.invalidators[id] = inv
+
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.invalidators, id)
+	}
+}
+
+// Get returns the template's content.
+func (l *MemoryLoader) Get(path string) (io.Reader, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	buf, has := l.templates[path]
+	if !has {
+		return nil, errors.Errorf("template '%s' not found", path)
+	}
+	return bytes.NewReader(buf), nil
+}
+
+// Stat returns the time the template has been added or changed the last time.
+func (l *MemoryLoader) Stat(path string) (time.Time, error) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	modified, has := l.modified[path]
+	if !has {
+		return time.Time{}, errors.Errorf("template '%s' not found", path)
+	}
+	return modified, nil
+}
+
+// Abs resolves a filename relative to the including template's path (given
+// in base). Names starting with a slash are resolved relative to the root.
+func (l *MemoryLoader) Abs(base, name string) string {
+	return slashAbs(base, name)
+}
+
+// slashAbs resolves a slash-separated, unrooted name relative to base's directory.
+func slashAbs(base, name string) string {
+	if strings.HasPrefix(name, "/") || base == "" {
+		return path.Clean(strings.TrimLeft(name, "/"))
+	}
+	return path.Join(path.Dir(base), name)
+}
+
+// ChainedLoader is a TemplateLoader which tries a list of loaders in order;
+// the first loader being able to load a template wins. It allows to override
+// single templates, for example using a tenant's theme directory, then the
+// product's theme and finally the built-in default templates.
+//
+// Template names are slash-separated and resolved like with the FSLoader.
+// A template may extend (or include) a template with its own name; the lookup
+// then continues with the loaders after the one which provided the template
+// itself. This way an override can extend the template it replaces:
+//
+//     {% extends "index.html" %}{% block content %}...{% endblock %}
+type ChainedLoader struct {
+	loaders []TemplateLoader
+}
+
+// chainedLoaderSep separates the template name from the index of the first
+// loader to consider within ChainedLoader's paths. It's a NUL byte since it
+// can't be part of any file name.
+const chainedLoaderSep = "\x00"
+
+// NewChainedLoader creates a new ChainedLoader which tries the given loaders in order.
+func NewChainedLoader(loaders ...TemplateLoader) *ChainedLoader {
+	return &ChainedLoader{
+		loaders: loaders,
+	}
+}
+
+// Abs resolves a filename relative to the including template's path (given in base).
+// If the filename resolves to the including template's own name, the returned
+// path skips all loaders up to and including the one providing the including template.
+func (l *ChainedLoader) Abs(base, name string) string {
+	name, start := l.split(name)
+	baseName, baseStart := l.split(base)
+
+	resolved := slashAbs(baseName, name)
+
+	if baseName != "" && resolved == baseName {
+		// The template references itself, continue the lookup
+		// after the loader which provided it.
+		start = l.find(baseName, baseStart) + 1
+	}
+
+	if start > 0 {
+		return fmt.Sprintf("%s%s%d", resolved, chainedLoaderSep, start)
+	}
+	return resolved
+}
+
+// Get returns the template's content from the first loader which is able to provide it.
+func (l *ChainedLoader) Get(path string) (io.Reader, error) {
+	name, start := l.split(path)
+
+	var errs []string
+	for _, loader := range l.loaders[min(start, len(l.loaders)):] {
+		r, err := loader.Get(loader.Abs("", name))
+		if err == nil {
+			return r, nil
+		}
+		if _, isSandboxError := err.(*SandboxError); isSandboxError {
+			// Never fall back to another loader in case of a sandbox violation
+			return nil, err
+		}
+		errs = append(errs, err.Error())
+	}
+	return nil, errors.Errorf("template '%s' not found in any loader (%s)", name, strings.Join(errs, "; "))
+}
+
+func (l *ChainedLoader) addInvalidator(inv cacheInvalidator) func() {
+	var removers []func()
+	for _, loader := range l.loaders {
+		if ol, ok := loader.(observableLoader); ok {
+			removers = append(removers, ol.addInvalidator(&chainedLoaderInvalidator{loader: l, inv: inv}))
+		}
+	}
+	return func() {
+		for _, remove := range removers {
+			remove()
+		}
+	}
+}
+
+// chainedLoaderInvalidator translates the names of a ChainedLoader's loaders
+// to the ChainedLoader's own names (which might include a loader index).
+type chainedLoaderInvalidator struct {
+	loader *ChainedLoader
+	inv    cacheInvalidator
+}
+
+func (ci *chainedLoaderInvalidator) invalidate(match func(path string) bool) {
+	ci.inv.invalidate(func(path string) bool {
+		name, _ := ci.loader.split(path)
+		return match(name)
+	})
+}
+
+// Stat returns the modification time of the template from the first loader
+// which is able to provide it. Loaders not implementing StatLoader report a
+// zero time for existing templates.
+func (l *ChainedLoader) Stat(path string) (time.Time, error) {
+	name, start := l.split(path)
+
+	for _, loader := range l.loaders[min(start, len(l.loaders)):] {
+		if statLoader, ok := loader.(StatLoader); ok {
+			if modified, err := statLoader.Stat(loader.Abs("", name)); err == nil {
+				return modified, nil
+			}
+			continue
+		}
+		if _, err := loader.Get(loader.Abs("", name)); err == nil {
+			return time.Time{}, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("template '%s' not found in any loader", name)
+}
+
+func (l *ChainedLoader) displayName(path string) string {
+	name, _ := l.split(path)
+	return name
+}
+
+// split separates the template name from the index of the first loader to consider.
+func (l *ChainedLoader) split(path string) (string, int) {
+	idx := strings.LastIndex(path, chainedLoaderSep)
+	if idx < 0 {
+		return path, 0
+	}
+	start, err := strconv.Atoi(path[idx+len(chainedLoaderSep):])
+	if err != nil || start < 0 {
+		return path, 0
+	}
+	return path[:idx], start
+}
+
+// find returns the index of the first loader (starting at start) which
+// is able to provide the template with the given name. Loaders implementing
+// StatLoader are asked using Stat() to avoid reading the template.
+func (l *ChainedLoader) find(name string, start int) int {
+	for idx := start; idx < len(l.loaders); idx++ {
+		loader := l.loaders[idx]
+		if statLoader, ok := loader.(StatLoader); ok {
+			if _, err := statLoader.Stat(loader.Abs("", name)); err == nil {
+				return idx
+			}
+			continue
+		}
+		if _, err := loader.Get(loader.Abs("", name)); err == nil {
+			return idx
+		}
+	}
+	return len(l.loaders)
+}
+
+// SandboxError is returned by the SandboxedFilesystemLoader whenever a
+// template outside of the allowed directories is requested.
+type SandboxError struct {
+	Path string
+}
+
+func (e *SandboxError) Error() string {
+	return fmt.Sprintf("access to '%s' denied (outside of the sandbox directories)", e.Path)
+}
+
+// SandboxedFilesystemLoader is a LocalFilesystemLoader which only permits
+// access to templates within a set of allowed directories. Every requested
+// path is canonicalized (including the resolution of "..", "." and symlinks)
+// before it's checked against the allowed directory patterns, so neither
+// relative paths nor symlinks can be used to escape the sandbox.
 type SandboxedFilesystemLoader struct {
 	*LocalFilesystemLoader
+
+	allowedDirs  []string // patterns as given (made absolute and cleaned)
+	resolvedDirs []string // the same patterns with symlinks resolved
 }
 
 // NewSandboxedFilesystemLoader creates a new sandboxed local file system instance.
-func NewSandboxedFilesystemLoader(baseDir string) (*SandboxedFilesystemLoader, error) {
+// allowedDirs is a list of directory patterns (see http://golang.org/pkg/path/filepath/#Match);
+// a template may be loaded if the template itself or any of its parent directories
+// matches at least one of the patterns (symlinks within a pattern's elements
+// before the first wildcard are resolved, the others are matched against the
+// resolved path of the template). Relative patterns are resolved against
+// the base directory (or the current working directory, if no base directory is
+// given). If no patterns are given, the base directory is the only allowed directory.
+func NewSandboxedFilesystemLoader(baseDir string, allowedDirs ...string) (*SandboxedFilesystemLoader, error) {
 	fs, err := NewLocalFileSystemLoader(baseDir)
 	if err != nil {
 		return nil, err
 	}
-	return &SandboxedFilesystemLoader{
+
+	if len(allowedDirs) == 0 {
+		if fs.baseDir == "" {
+			return nil, errors.New("a sandboxed loader requires either a base directory or at least one allowed directory")
+		}
+		allowedDirs = []string{fs.baseDir}
+	}
+
+	sfs := &SandboxedFilesystemLoader{
 		LocalFilesystemLoader: fs,
-	}, nil
-}
-
-// Move sandbox to a virtual fs
-
-/*
-if len(set.SandboxDirectories) > 0 {
-    defer func() {
-        // Remove any ".." or other crap
-        resolvedPath = filepath.Clean(resolvedPath)
-
-        // Make the path absolute
-        absPath, err := filepath.Abs(resolvedPath)
-        if err != nil {
-            panic(err)
-        }
-        resolvedPath = absPath
-
-        // Check against the sandbox directories (once one pattern matches, we're done and can allow it)
-        for _, pattern := range set.SandboxDirectories {
-            matched, err := filepath.Match(pattern, resolvedPath)
-            if err != nil {
-                panic("Wrong sandbox directory match pattern (see http://golang.org/pkg/path/filepath/#Match).")
-            }
-            if matched {
-                // OK!
-                return
-            }
-        }
-
-        // No pattern matched, we have to log+deny the request
-        set.logf("Access attempt outside of the sandbox directories (blocked): '%s'", resolvedPath)
-        resolvedPath = ""
-    }()
-}
-*/
+	}
+	for _, pattern := range allowedDirs {
+		if !filepath.IsAbs(pattern) {
+			base := fs.baseDir
+			if base == "" {
+				base, err = os.Getwd()
+				if err != nil {
+					return nil, err
+				}
+			}
+			pattern = filepath.Join(base, pattern)
+		}
+		pattern = filepath.Clean(pattern)
+
+		// Check the pattern's syntax
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return nil, errors.Errorf("invalid sandbox directory pattern '%s': %s", pattern, err)
+		}
+
+		sfs.allowedDirs = append(sfs.allowedDirs, pattern)
+		sfs.resolvedDirs = append(sfs.resolvedDirs, resolvePattern(pattern))
+	}
+
+	return sfs, nil
+}
+
+// Get reads the path's content from your local filesystem if the
+// path is within one of the allowed directories. Otherwise a
+// *SandboxError is returned.
+func (fs *SandboxedFilesystemLoader) Get(path string) (io.Reader, error) {
+	resolvedPath, err := fs.canonicalize(path)
+	if err != nil {
+		return nil, err
+	}
+	return fs.LocalFilesystemLoader.Get(resolvedPath)
+}
+
+// canonicalize returns the cleaned, absolute and symlink-free version
+// of path if it's within the sandbox.
+func (fs *SandboxedFilesystemLoader) canonicalize(path string) (string, error) {
+	absPath, err := filepath.Abs(path) // also removes any ".."
+	if err != nil {
+		return "", err
+	}
+
+	// First check the path itself, so we're not leaking whether
+	// files outside of the sandbox exist.
+	if !isWithinDirs(fs.allowedDirs, absPath) {
+		return "", &SandboxError{Path: path}
+	}
+
+	resolvedPath, err := filepath.EvalSymlinks(absPath)
+	if err != nil {
+		return "", err
+	}
+	if !isWithinDirs(fs.resolvedDirs, resolvedPath) {
+		return "", &SandboxError{Path: path}
+	}
+
+	return resolvedPath, nil
+}
+
+// Stat returns the modification time of the file at path if the
+// path is within one of the allowed directories.
+func (fs *SandboxedFilesystemLoader) Stat(path string) (time.Time, error) {
+	resolvedPath, err := fs.canonicalize(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return fs.LocalFilesystemLoader.Stat(resolvedPath)
+}
+
+// resolvePattern returns the canonical form of a directory pattern, since
+// canonicalized paths are compared against it: The symlinks within its static
+// prefix (the elements before the first one containing wildcards) are resolved;
+// the remaining elements are matched against the resolved paths.
+func resolvePattern(pattern string) string {
+	prefix, rest := pattern, ""
+	for strings.ContainsAny(prefix, `*?[\`) {
+		rest = filepath.Join(filepath.Base(prefix), rest)
+		prefix = filepath.Dir(prefix)
+	}
+	resolved, err := filepath.EvalSymlinks(prefix)
+	if err != nil {
+		return pattern
+	}
+	return filepath.Join(resolved, rest)
+}
+
+// isWithinDirs reports whether path or any of its parent directories
+// matches one of the patterns.
+func isWithinDirs(patterns []string, path string) bool {
+	for _, pattern := range patterns {
+		// Check the path and all of its parents against the pattern
+		for dir := path; ; dir = filepath.Dir(dir) {
+			if matched, _ := filepath.Match(pattern, dir); matched {
+				return true
+			}
+			if dir == filepath.Dir(dir) {
+				break
+			}
+		}
+	}
+	return false
+}
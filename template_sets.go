@@ -7,6 +7,8 @@ import (
 	"log"
 	"os"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/juju/errors"
 )
@@ -21,6 +23,37 @@ type TemplateLoader interface {
 	Get(path string) (io.Reader, error)
 }
 
+// StatLoader is an optional interface for TemplateLoaders which are able to
+// tell when a template has been changed. It's required for TemplateSet.AutoReload.
+type StatLoader interface {
+	TemplateLoader
+
+	// Stat returns the modification time of the template at the given
+	// (already resolved) path or an error if the template does not exist.
+	Stat(path string) (time.Time, error)
+}
+
+// cacheInvalidator is implemented by everything caching compiled templates
+// (the TemplateSet). Loaders whose templates might change at runtime use it
+// to evict stale templates.
+type cacheInvalidator interface {
+	invalidate(match func(path string) bool)
+}
+
+// observableLoader is implemented by loaders whose templates might change
+// at runtime. The TemplateSet registers itself to get notified about changes;
+// the returned function unregisters it again.
+type observableLoader interface {
+	addInvalidator(cacheInvalidator) (remove func())
+}
+
+// displayNamer is implemented by loaders whose resolved names carry internal
+// information (like the ChainedLoader's loader index) which must not be shown
+// to users.
+type displayNamer interface {
+	displayName(path string) string
+}
+
 // TemplateSet allows you to create your own group of templates with their own
 // global context (which is shared among all members of the set) and their own
 // configuration.
@@ -39,33 +72,123 @@ type TemplateSet struct {
 	// variable during program execution (and template compilation/execution).
 	Debug bool
 
+	// If AutoReload is true (default false), FromCache() checks whether a cached
+	// template or one of its dependencies (parents, static includes, imports and
+	// SSIs) has been changed and recompiles the template if so. The check is done
+	// at most once per AutoReloadInterval for each template (on every call to
+	// FromCache() if the interval is 0). AutoReload requires a loader implementing
+	// StatLoader; it's ignored otherwise.
+	AutoReload         bool
+	AutoReloadInterval time.Duration
+
+	// CacheSize limits the amount of templates cached by FromCache() (default 0,
+	// which means no limit). If the limit is exceeded, the least recently used
+	// templates are evicted from the cache.
+	CacheSize int
+
+	// Undefined defines how undefined variables are handled (default
+	// UndefinedSilent). If UndefinedHandler is set, it's called for every undefined
+	// variable instead. Neither applies to variables used directly as truth values
+	// in the conditions of {% if %} and {% firstof %} (e. g. {% if user.is_admin %}),
+	// to the operands of tests like 'defined' or to variables filtered by
+	// default/default_if_none; undefined variables are always nil there.
+	Undefined        UndefinedPolicy
+	UndefinedHandler UndefinedHandler
+
+	// Whitespace control: if TrimBlocks is true, the first newline after a
+	// tag or comment is removed. If LstripBlocks is true, spaces and tabs are
+	// stripped from the start of a line up to a tag or comment. Independently,
+	// whitespace can be stripped around any tag, variable or comment using
+	// '-' (like {%- ... -%}, {{- ... -}} and {#- ... -#}).
+	TrimBlocks   bool
+	LstripBlocks bool
+
+	// Resource limits for the execution of (untrusted) templates. A template
+	// execution exceeding a limit is aborted with an *Error whose OrigError
+	// is a *LimitError. A limit of 0 (the default) means no limit.
+	//
+	// MaxOutputBytes limits the size of the output, MaxLoopIterations the total
+	// amount of for-loop iterations (including the evaluations of a loop's
+	// if-condition and lorem items), MaxNodes the total amount
+	// of executed nodes and MaxRecursionDepth the nesting depth of includes and
+	// macro calls. MaxRecursionDepth defaults to 1000 to prevent stack overflows.
+	MaxOutputBytes    int
+	MaxLoopIterations int
+	MaxNodes          int
+	MaxRecursionDepth int
+
 	// Sandbox features
-	// - Disallow access to specific tags and/or filters (using BanTag() and BanFilter())
+	// - Disallow access to specific tags, filters and/or tests (using BanTag(),
+	//   BanFilter() and BanTest())
 	//
-	// For efficiency reasons you can ban tags/filters only *before* you have
+	// For efficiency reasons you can ban tags/filters/tests only *before* you have
 	// added your first template to the set (restrictions are statically checked).
 	// After you added one, it's not possible anymore (for your personal security).
-	firstTemplateCreated bool
+	firstTemplateCreated uint32 // accessed atomically, see markTemplateCreated()
 	bannedTags           map[string]bool
 	bannedFilters        map[string]bool
+	bannedTests          map[string]bool
+
+	// Filters, tags and tests registered for this set only (see RegisterFilter(),
+	// RegisterTag() and RegisterTest()); they take precedence over the global ones.
+	filters       map[string]*filter
+	tags          map[string]*tag
+	tests         map[string]*test
+	registryMutex sync.RWMutex
 
 	// Template cache (for FromCache())
-	templateCache      map[string]*Template
-	templateCacheMutex sync.Mutex
+	templateCache *templateCache
+
+	// Unregisters the set from an observableLoader (see Close())
+	unobserve func()
+	closeOnce sync.Once
 }
 
 // NewSet can be used to create sets with different kind of templates
 // (e. g. web from mail templates), with different globals or
 // other configurations.
 func NewSet(name string, loader TemplateLoader) *TemplateSet {
-	return &TemplateSet{
+	set := &TemplateSet{
 		name:          name,
 		loader:        loader,
 		Globals:       make(Context),
 		bannedTags:    make(map[string]bool),
 		bannedFilters: make(map[string]bool),
-		templateCache: make(map[string]*Template),
+		bannedTests:   make(map[string]bool),
+		filters:       make(map[string]*filter),
+		tags:          make(map[string]*tag),
+		tests:         make(map[string]*test),
+		templateCache: newTemplateCache(),
+	}
+
+	// Get notified about changed templates (e. g. by the MemoryLoader)
+	if ol, ok := loader.(observableLoader); ok {
+		set.unobserve = ol.addInvalidator(set)
 	}
+
+	return set
+}
+
+// Close unregisters the set from its loader. Loaders notifying sets about
+// changed templates (like the MemoryLoader) keep every set using them alive,
+// so close the sets you create dynamically (e. g. per tenant or request) once
+// you don't need them anymore. A closed set is still usable, but it doesn't
+// notice changes made through the loader anymore.
+func (set *TemplateSet) Close() {
+	set.closeOnce.Do(func() {
+		if set.unobserve != nil {
+			set.unobserve()
+		}
+	})
+}
+
+// displayName returns the name of a resolved template as shown to users,
+// e. g. within errors.
+func (set *TemplateSet) displayName(path string) string {
+	if namer, ok := set.loader.(displayNamer); ok {
+		return namer.displayName(path)
+	}
+	return path
 }
 
 func (set *TemplateSet) resolveFilename(tpl *Template, path string) string {
@@ -79,13 +202,161 @@ func (set *TemplateSet) resolveFilename(tpl *Template, path string) string {
 	return set.loader.Abs(name, path)
 }
 
+// RegisterFilter registers a new filter for this set only. It's not allowed
+// to register a filter which already exists, either within the set or globally
+// (use ReplaceFilter() to override a global filter for this set).
+// It's safe to call RegisterFilter concurrently; templates which have been
+// compiled already are not affected.
+func (set *TemplateSet) RegisterFilter(name string, fn FilterFunction) error {
+	return set.registerFilter(name, &filter{name: name, fn: fn})
+}
+
+// RegisterFilterWithArgs registers a new filter taking positional and keyword
+// arguments (see FilterFunctionWithArgs) for this set only.
+func (set *TemplateSet) RegisterFilterWithArgs(name string, fn FilterFunctionWithArgs) error {
+	return set.registerFilter(name, &filter{name: name, fnWithArgs: fn})
+}
+
+// ReplaceFilter replaces an already registered filter (of this set or a
+// global one) with a new implementation for this set only.
+func (set *TemplateSet) ReplaceFilter(name string, fn FilterFunction) error {
+	return set.replaceFilter(name, &filter{name: name, fn: fn})
+}
+
+// ReplaceFilterWithArgs works like ReplaceFilter, but for filters
+// taking positional and keyword arguments.
+func (set *TemplateSet) ReplaceFilterWithArgs(name string, fn FilterFunctionWithArgs) error {
+	return set.replaceFilter(name, &filter{name: name, fnWithArgs: fn})
+}
+
+func (set *TemplateSet) registerFilter(name string, f *filter) error {
+	set.registryMutex.Lock()
+	defer set.registryMutex.Unlock()
+	if _, existing := set.filters[name]; existing || FilterExists(name) {
+		return errors.Errorf("filter with name '%s' is already registered", name)
+	}
+	set.filters[name] = f
+	return nil
+}
+
+func (set *TemplateSet) replaceFilter(name string, f *filter) error {
+	set.registryMutex.Lock()
+	defer set.registryMutex.Unlock()
+	if _, existing := set.filters[name]; !existing && !FilterExists(name) {
+		return errors.Errorf("filter with name '%s' does not exist (therefore cannot be overridden)", name)
+	}
+	set.filters[name] = f
+	return nil
+}
+
+// RegisterTag registers a new tag for this set only. It's not allowed
+// to register a tag which already exists, either within the set or globally
+// (use ReplaceTag() to override a global tag for this set).
+// It's safe to call RegisterTag concurrently; templates which have been
+// compiled already are not affected.
+func (set *TemplateSet) RegisterTag(name string, parserFn TagParser) error {
+	set.registryMutex.Lock()
+	defer set.registryMutex.Unlock()
+	if _, existing := set.tags[name]; existing {
+		return errors.Errorf("tag with name '%s' is already registered", name)
+	}
+	if _, existing := getTag(name); existing {
+		return errors.Errorf("tag with name '%s' is already registered", name)
+	}
+	set.tags[name] = &tag{
+		name:   name,
+		parser: parserFn,
+	}
+	return nil
+}
+
+// ReplaceTag replaces an already registered tag (of this set or a
+// global one) with a new implementation for this set only.
+func (set *TemplateSet) ReplaceTag(name string, parserFn TagParser) error {
+	set.registryMutex.Lock()
+	defer set.registryMutex.Unlock()
+	_, existing := set.tags[name]
+	if !existing {
+		_, existing = getTag(name)
+	}
+	if !existing {
+		return errors.Errorf("tag with name '%s' does not exist (therefore cannot be overridden)", name)
+	}
+	set.tags[name] = &tag{
+		name:   name,
+		parser: parserFn,
+	}
+	return nil
+}
+
+// RegisterTest registers a new test for this set only. It's not allowed
+// to register a test which already exists, either within the set or globally
+// (use ReplaceTest() to override a global test for this set).
+func (set *TemplateSet) RegisterTest(name string, fn TestFunction) error {
+	set.registryMutex.Lock()
+	defer set.registryMutex.Unlock()
+	if _, existing := set.tests[name]; existing || TestExists(name) {
+		return errors.Errorf("test with name '%s' is already registered", name)
+	}
+	set.tests[name] = &test{name: name, fn: fn}
+	return nil
+}
+
+// ReplaceTest replaces an already registered test (of this set or a
+// global one) with a new implementation for this set only.
+func (set *TemplateSet) ReplaceTest(name string, fn TestFunction) error {
+	set.registryMutex.Lock()
+	defer set.registryMutex.Unlock()
+	if _, existing := set.tests[name]; !existing && !TestExists(name) {
+		return errors.Errorf("test with name '%s' does not exist (therefore cannot be overridden)", name)
+	}
+	set.tests[name] = &test{name: name, fn: fn}
+	return nil
+}
+
+// getFilter returns the filter with the given name; filters registered
+// for this set take precedence over the global ones.
+func (set *TemplateSet) getFilter(name string) (*filter, bool) {
+	set.registryMutex.RLock()
+	f, existing := set.filters[name]
+	set.registryMutex.RUnlock()
+	if existing {
+		return f, true
+	}
+	return getFilter(name)
+}
+
+// getTag returns the tag with the given name; tags registered
+// for this set take precedence over the global ones.
+func (set *TemplateSet) getTag(name string) (*tag, bool) {
+	set.registryMutex.RLock()
+	t, existing := set.tags[name]
+	set.registryMutex.RUnlock()
+	if existing {
+		return t, true
+	}
+	return getTag(name)
+}
+
+// getTest returns the test with the given name; tests registered
+// for this set take precedence over the global ones.
+func (set *TemplateSet) getTest(name string) (*test, bool) {
+	set.registryMutex.RLock()
+	t, existing := set.tests[name]
+	set.registryMutex.RUnlock()
+	if existing {
+		return t, true
+	}
+	return getTest(name)
+}
+
 // BanTag bans a specific tag for this template set. See more in the documentation for TemplateSet.
 func (set *TemplateSet) BanTag(name string) error {
-	_, has := tags[name]
+	_, has := set.getTag(name)
 	if !has {
 		return errors.Errorf("tag '%s' not found", name)
 	}
-	if set.firstTemplateCreated {
+	if set.templateCreated() {
 		return errors.New("you cannot ban any tags after you've added your first template to your template set")
 	}
 	_, has = set.bannedTags[name]
@@ -99,11 +370,11 @@ func (set *TemplateSet) BanTag(name string) error {
 
 // BanFilter bans a specific filter for this template set. See more in the documentation for TemplateSet.
 func (set *TemplateSet) BanFilter(name string) error {
-	_, has := filters[name]
+	_, has := set.getFilter(name)
 	if !has {
 		return errors.Errorf("filter '%s' not found", name)
 	}
-	if set.firstTemplateCreated {
+	if set.templateCreated() {
 		return errors.New("you cannot ban any filters after you've added your first template to your template set")
 	}
 	_, has = set.bannedFilters[name]
@@ -115,60 +386,89 @@ func (set *TemplateSet) BanFilter(name string) error {
 	return nil
 }
 
-// FromCache is a convenient method to cache templates. It is thread-safe
-// and will only compile the template associated with a filename once.
-// If TemplateSet.Debug is true (for example during development phase),
-// FromCache() will not cache the template and instead recompile it on any
-// call (to make changes to a template live instantaneously).
-func (set *TemplateSet) FromCache(filename string) (*Template, error) {
-	if set.Debug {
-		// Recompile on any request
-		return set.FromFile(filename)
+// BanTest bans a specific test for this template set. See more in the documentation for TemplateSet.
+func (set *TemplateSet) BanTest(name string) error {
+	_, has := set.getTest(name)
+	if !has {
+		return errors.Errorf("test '%s' not found", name)
 	}
-	// Cache the template
-	cleanedFilename := set.resolveFilename(nil, filename)
-
-	set.templateCacheMutex.Lock()
-	defer set.templateCacheMutex.Unlock()
+	if set.templateCreated() {
+		return errors.New("you cannot ban any tests after you've added your first template to your template set")
+	}
+	_, has = set.bannedTests[name]
+	if has {
+		return errors.Errorf("test '%s' is already banned", name)
+	}
+	set.bannedTests[name] = true
 
-	tpl, has := set.templateCache[cleanedFilename]
+	return nil
+}
 
-	// Cache miss
-	if !has {
-		tpl, err := set.FromFile(cleanedFilename)
-		if err != nil {
-			return nil, err
-		}
-		set.templateCache[cleanedFilename] = tpl
-		return tpl, nil
-	}
+// markTemplateCreated records that templates have been created (possibly
+// concurrently); tags, filters and tests can't be banned anymore afterwards.
+func (set *TemplateSet) markTemplateCreated() {
+	atomic.StoreUint32(&set.firstTemplateCreated, 1)
+}
 
-	// Cache hit
-	return tpl, nil
+func (set *TemplateSet) templateCreated() bool {
+	return atomic.LoadUint32(&set.firstTemplateCreated) == 1
 }
 
 // FromString loads a template from string and returns a Template instance.
 func (set *TemplateSet) FromString(tpl string) (*Template, error) {
-	set.firstTemplateCreated = true
+	set.markTemplateCreated()
 
 	return newTemplateString(set, []byte(tpl))
 }
 
 // FromBytes loads a template from bytes and returns a Template instance.
 func (set *TemplateSet) FromBytes(tpl []byte) (*Template, error) {
-	set.firstTemplateCreated = true
+	set.markTemplateCreated()
 
 	return newTemplateString(set, tpl)
 }
 
 // FromFile loads a template from a filename and returns a Template instance.
 func (set *TemplateSet) FromFile(filename string) (*Template, error) {
-	set.firstTemplateCreated = true
+	return set.fromFile(filename, nil)
+}
+
+// fromFile loads a template which is included (imported, extended) by includer.
+func (set *TemplateSet) fromFile(filename string, includer *Template) (*Template, error) {
+	set.markTemplateCreated()
+
+	// Detect recursive templates (they would never finish compiling)
+	maxDepth := set.MaxRecursionDepth
+	if maxDepth <= 0 {
+		maxDepth = defaultMaxRecursionDepth
+	}
+	depth := 0
+	for t := includer; t != nil; t = t.includer {
+		if !t.isTplString && set.resolveFilename(nil, t.name) == set.resolveFilename(nil, filename) {
+			return nil, &Error{
+				Filename:  set.displayName(filename),
+				Sender:    "parser",
+				OrigError: errors.Errorf("template '%s' recursively includes itself", set.displayName(filename)),
+			}
+		}
+		depth++
+		if depth >= maxDepth {
+			return nil, &Error{
+				Filename:  set.displayName(filename),
+				Sender:    "parser",
+				OrigError: &LimitError{Limit: "recursion depth", Max: maxDepth},
+			}
+		}
+	}
+
+	// Take the modification time before reading the template, so changes
+	// made while it's being compiled are detected by AutoReload later on.
+	stamp, stamped := set.stamp(filename)
 
 	fd, err := set.loader.Get(set.resolveFilename(nil, filename))
 	if err != nil {
 		return nil, &Error{
-			Filename:  filename,
+			Filename:  set.displayName(filename),
 			Sender:    "fromfile",
 			OrigError: err,
 		}
@@ -176,18 +476,25 @@ func (set *TemplateSet) FromFile(filename string) (*Template, error) {
 	buf, err := ioutil.ReadAll(fd)
 	if err != nil {
 		return nil, &Error{
-			Filename:  filename,
+			Filename:  set.displayName(filename),
 			Sender:    "fromfile",
 			OrigError: err,
 		}
 	}
 
-	return newTemplate(set, filename, false, buf)
+	tpl, err := newTemplate(set, filename, false, buf, includer)
+	if err != nil {
+		return nil, err
+	}
+	if stamped {
+		tpl.addStamp(filename, stamp)
+	}
+	return tpl, nil
 }
 
 // RenderTemplateString is a shortcut and renders a template string directly.
 func (set *TemplateSet) RenderTemplateString(s string, ctx Context) (string, error) {
-	set.firstTemplateCreated = true
+	set.markTemplateCreated()
 
 	tpl := Must(set.FromString(s))
 	result, err := tpl.Execute(ctx)
@@ -199,7 +506,7 @@ func (set *TemplateSet) RenderTemplateString(s string, ctx Context) (string, err
 
 // RenderTemplateBytes is a shortcut and renders template bytes directly.
 func (set *TemplateSet) RenderTemplateBytes(b []byte, ctx Context) (string, error) {
-	set.firstTemplateCreated = true
+	set.markTemplateCreated()
 
 	tpl := Must(set.FromBytes(b))
 	result, err := tpl.Execute(ctx)
@@ -211,7 +518,7 @@ func (set *TemplateSet) RenderTemplateBytes(b []byte, ctx Context) (string, erro
 
 // RenderTemplateFile is a shortcut and renders a template file directly.
 func (set *TemplateSet) RenderTemplateFile(fn string, ctx Context) (string, error) {
-	set.firstTemplateCreated = true
+	set.markTemplateCreated()
 
 	tpl := Must(set.FromFile(fn))
 	result, err := tpl.Execute(ctx)
@@ -0,0 +1,9 @@
+{% macro card(title, kind="default") %}<div class="card {{ kind }}"><h1>{{ title }}</h1>{{ caller() }}</div>{% endmacro %}
+{% call card("Welcome") %}<p>Hello {{ simple.name }}!</p>{% endcall %}
+{% call card(title="<News>", kind="primary") %}{{ "<b>escaped</b>" }}{% endcall %}
+{% macro list(items) %}<ul>{% for item in items %}<li>{{ caller(item, forloop.Counter) }}</li>{% endfor %}</ul>{% endmacro %}
+{% call(item, idx) list(simple.misc_list) %}{{ idx }}: {{ item }}{% endcall %}
+{% macro each(items) %}{% for item in items %}{{ caller(item) }} {% endfor %}{% endmacro %}
+{% call(item, suffix="!") each(simple.multiple_item_list) %}{{ item }}{{ suffix }}{% endcall %}
+{% call card("Outer") %}{% call card("Inner", kind="nested") %}body{% endcall %}{% endcall %}
+{% macro twice() %}{{ caller() }}{{ caller() }}{% endmacro %}{% call twice() %}[{{ simple.number }}]{% endcall %}
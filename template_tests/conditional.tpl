@@ -0,0 +1,8 @@
+{{ "yes" if simple.bool_true else "no" }} {{ "yes" if simple.bool_false else "no" }} {{ "a" if simple.nil else "b" if simple.number > 40 else "c" }}
+{{ "x" if simple.number == 42 and simple.bool_true else "y" }} {{ (simple.number if simple.bool_false else simple.uint) + 1 }} {{ simple.name|upper if simple.bool_true else simple.name }}
+{% set cls = "active" if simple.number > 10 else "inactive" %}<li class="{{ cls }}">
+{{ simple.nil|default:("first" if simple.bool_false else "second") }} {{ [1, 2, 3]|join:("-" if simple.bool_true else ",") }}
+{% macro btn(label, cls="primary" if simple.bool_true else "secondary") %}<button class="{{ cls }}">{{ label }}</button>{% endmacro %}{{ btn("OK") }}
+{% with v=("big" if simple.number > 100 else "small") %}{{ v }}{% endwith %}
+{% include "includes.helper" with what_am_i=("conditional" if simple.bool_true else "never") only %}
+{% if "yes" if simple.bool_false else "" %}wrong{% else %}right{% endif %}
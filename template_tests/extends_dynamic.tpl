@@ -0,0 +1,3 @@
+{% extends "inheritance/base2.tpl" if simple.bool_false else "inheritance/base.tpl" %}
+
+{% block content %}Dynamic content ({{ simple.name }}){% endblock %}
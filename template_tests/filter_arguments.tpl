@@ -0,0 +1,5 @@
+{{ simple.name|replace("john", "jane") }} {{ "aaa"|replace("a", "b", 2) }} {{ "aaa"|replace(old="a", new="c", count=1) }} {{ simple.name|replace("o", "0")|upper }}
+{{ simple.bool_true|yesno("ja", "nein") }} {{ simple.nil|yesno("ja", "nein", "vielleicht",) }} {{ simple.bool_false|yesno:"ja,nein" }}
+customer{{ 2|pluralize("y", "ies") }} customer{{ 1|pluralize("y", "ies") }} walrus{{ 2|pluralize("es") }} cherr{{ 2|pluralize:"y,ies" }}
+{{ simple.nil|default("fallback") }} {{ simple.name|truncatechars(4) }} {{ simple.name|upper() }} {{ simple.number|add(simple.number if simple.bool_true else 0) }}
+{% filter replace("a", "4")|upper %}banana{% endfilter %}
@@ -0,0 +1,10 @@
+{% for item in simple.multiple_item_list %}{{ forloop.Counter }}/{{ forloop.Length }} {% endfor %}
+{% for item in simple.multiple_item_list if item > 5 %}{{ item }}:{{ forloop.Revcounter }}/{{ forloop.Revcounter0 }}{% if forloop.First %}(first){% endif %}{% if forloop.Last %}(last){% endif %} {% endfor %}
+{% for outer in simple.misc_list %}{% for inner in simple.one_item_list %}[{{ forloop.Depth }}/{{ forloop.Depth0 }}/{{ forloop.Parentloop.Depth }}]{% endfor %}{% endfor %}
+{% for item in simple.misc_list %}{{ forloop.Previtem|default:"-" }}<{{ item }}>{{ forloop.Nextitem|default:"-" }} {% endfor %}
+{% for item in simple.multiple_item_list %}<li class="{{ forloop.Cycle("odd", "even") }}">{{ item }}</li>{% endfor %}
+{% for item in simple.multiple_item_list %}{% if forloop.Changed(item) %}{{ item }} {% endif %}{% endfor %}
+{% for item in simple.multiple_item_list %}{% if forloop.Changed(item > 4, item > 20) %}{{ item }} {% endif %}{% endfor %}
+{% for number, name in [[1, "one"], [2, "two"], [3, "three"]] %}{{ number }}={{ name }} {% endfor %}
+{% for author, date, text in complex.comments %}{{ author.Name }}: {{ text }} {% endfor %}
+{% for key, value in simple.strmap sorted %}{{ key }}={{ value }} {% endfor %}
@@ -0,0 +1,8 @@
+{% for x in ["a", "b", 'c',] %}{{ x }}{% endfor %}
+{{ []|length }} {{ [1, 2, 3]|length }} {{ [1, [2, 3], []]|length }}
+{{ ["b", "a"]|join:", " }} {{ [1, 2, 3]|last }} {{ [simple.name, 5 + 3]|join:"-" }}
+{% if "b" in ["a", "b"] %}contains{% endif %} {% if 5 in [1, 2] %}wrong{% else %}no{% endif %}
+{% for k, v in {"class": "btn", "id": simple.number,} sorted %}{{ k }}={{ v }};{% endfor %}
+{{ {"a": {"b": [1, 2]}}|length }} {{ {"a": 1}|length }} {% if "a" in {"a": 1} %}key{% endif %}
+{% with attrs={"class": "btn", "nested": {"x": [1, {"y": "z"}]}} %}{{ attrs.class }} {{ attrs.nested.x.1.y }}{% endwith %}
+{{ {}|length }}
@@ -0,0 +1,11 @@
+{% for item in simple.multiple_item_list %}{% if item > 10 %}{% break %}{% endif %}{{ item }} {% endfor %}
+{% for item in simple.multiple_item_list %}{% if item|divisibleby:2 %}{% continue %}{% endif %}{{ item }} {% endfor %}
+{% for outer in simple.misc_list %}{% for item in simple.multiple_item_list %}{% with x=item %}{% if x > 2 %}{% break %}{% endif %}{% endwith %}{{ outer }}/{{ item }} {% endfor %}{% if forloop.Counter == 2 %}{% break %}{% endif %}{% endfor %}
+{% for item in simple.multiple_item_list %}{% filter upper %}{% if item == 3 %}{% continue %}{% endif %}{{ item }} {% endfilter %}{% endfor %}
+{% for item in simple.multiple_item_list if item|divisibleby:2 %}{{ forloop.Counter }}/{{ forloop.Revcounter }}:{{ item }}{% if forloop.Last %} (last){% endif %} {% endfor %}
+{% for item in simple.multiple_item_list if item > 100 %}{{ item }}{% empty %}no items{% endfor %}
+{% for item in simple.multiple_item_list reversed if item < 5 %}{{ item }} {% endfor %}
+{% for key, value in simple.strmap sorted if value != "" %}{{ key }}={{ value }} {% endfor %}
+{% macro first_even(items) %}{% for item in items %}{% if item|divisibleby:2 %}{{ item }}{% break %}{% endif %}{% endfor %}{% endmacro %}{{ first_even(simple.multiple_item_list) }}
+{% for item in simple.multiple_item_list %}{% filter upper %}a{{ item }}{% if item == 2 %}{% break %}{% endif %}{% endfilter %}{% endfor %}
+{% set ns = namespace(items="") %}{% for item in simple.multiple_item_list %}{% set ns.items %}{{ ns.items }}{{ item }}{% if item == 2 %}{% break %}{% endif %};{% endset %}{% endfor %}{{ ns.items }}
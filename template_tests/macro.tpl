@@ -7,7 +7,8 @@ Greetings to {{ to }} from {{ from }}. Howdy, {% if name2 == "guest" %}anonymous
 {{ greetings("john") }}
 {{ greetings("john", "michelle") }}
 {{ greetings("john", "michelle", "johann") }}
-{{ greetings("john", "michelle", "johann", "foobar") }}
+{{ greetings("john", name2="johann") }}
+{{ greetings(name2="johann", to="john", from="michelle") }}
 
 {% macro test2(loop, value) %}map[{{ loop.Counter0 }}] = {{ value }}{% endmacro %}
 {% for item in simple.misc_list %}
@@ -0,0 +1,6 @@
+{% set greeting %}<b>Hello {{ simple.name }}</b>{% endset %}{{ greeting }}|{{ greeting|length }}
+{% for item in simple.misc_list %}{% set row %}[{{ forloop.Counter }}: {{ item }}]{% endset %}{{ row }}{% endfor %}
+{% set ns = namespace(total=0, found=false) %}{% for item in simple.multiple_item_list %}{% set ns.total = ns.total + item %}{% if item == 13 %}{% set ns.found = true %}{% endif %}{% endfor %}{{ ns.total }} {{ ns.found }}
+{% set ns = namespace() %}{% for item in simple.misc_list %}{% with x=item %}{% set ns.last = x %}{% endwith %}{% endfor %}{{ ns.last }}
+{% set ns = namespace(items="") %}{% for item in simple.multiple_item_list %}{% set ns.items %}{{ ns.items }}{{ item }};{% endset %}{% endfor %}{{ ns.items }}
+{% set total = 0 %}{% for item in simple.multiple_item_list %}{% set total = total + item %}{% endfor %}{{ total }}
@@ -0,0 +1,8 @@
+{{ simple["name"] }} {{ simple["number"] }} {{ simple.strmap["abc"] }} {% with key="gh" %}{{ simple.strmap[key] }}{% endwith %}
+{{ simple.intmap[1] }} {{ simple.intmap[2] }} {{ simple.intmap.5 }} [{{ simple.intmap[3] }}]
+{{ simple.multiple_item_list[0] }} {{ simple.multiple_item_list[-1] }} {{ simple.multiple_item_list[1 + 2] }} [{{ simple.multiple_item_list[10] }}]
+{{ simple.multiple_item_list[2:5]|join:"," }} {{ simple.multiple_item_list[:3]|join:"," }} {{ simple.multiple_item_list[-2:]|join:"," }} {{ simple.multiple_item_list[5:2]|length }} {{ simple.multiple_item_list[:]|length }}
+{{ simple.chinese_hello_world[1] }} {{ simple.chinese_hello_world[-2:] }} {{ simple.name[0:4]|upper }}
+{{ complex.comments[1].Author.Name }} {{ complex.comments[-1]["Text"] }} {{ simple["func_add"](2, 3) }}
+{% for c in complex.comments[1:] %}{{ c.Author.Name }} {% endfor %}
+{{ simple.misc_list[simple.one_item_list[0] - 98] }} {% if "abc" in simple.strmap %}{{ simple["strmap"]["abc"] }}{% endif %}
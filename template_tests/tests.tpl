@@ -0,0 +1,16 @@
+{{ simple.name is defined }} {{ simple.missing is defined }} {{ missing.nested is not defined }} {{ simple.nil is defined }}
+{{ simple.nil is none }} {{ simple.missing is none }} {{ simple.name is not none }}
+{{ simple.number is number }} {{ simple.float is number }} {{ simple.name is number }}
+{{ simple.name is string }} {{ simple.number is string }}
+{{ simple.misc_list is iterable }} {{ simple.strmap is iterable }} {{ simple.name is iterable }} {{ simple.number is iterable }}
+{{ simple.strmap is mapping }} {{ simple.misc_list is mapping }}
+{{ 2 is even }} {{ 3 is even }} {{ 3 is odd }} {{ simple.float is odd }} {{ "abc" is even }}
+{{ 9 is divisibleby 3 }} {{ 10 is divisibleby(3) }} {{ 10 is not divisibleby(3) }} {{ 10 is divisibleby 0 }} {{ "abc" is divisibleby 3 }} {{ 2.5 is divisibleby 2 }} {{ 6 is divisibleby "x" }}
+{{ simple.strmap is sameas(simple.strmap) }} {{ simple.misc_list is sameas(simple.misc_list) }} {{ simple.misc_list is sameas([]) }} {{ 1 is sameas 1 }}
+{{ 99 is in(simple.misc_list) }} {{ 100 is in(simple.misc_list) }} {{ "oo" is in "foobar" }} {{ 99 is in simple.misc_list }} {{ 3 is not in simple.misc_list }} {{ simple.number is divisibleby simple.number }} {{ 2 is in [1, 2] }} {{ "b" is in {"a": 1} }}
+{{ not simple.missing is defined }} {{ simple.missing is not defined }} {{ not simple.name is defined }} {{ simple.name is not defined }} {{ not 3 is not odd }} {% if not simple.missing is defined %}missing{% endif %}
+{{ "" is empty }} {{ simple.misc_list is empty }} {{ [] is empty }} {{ simple.missing is empty }} {{ 0 is empty }}
+{% if simple.number is number and simple.number is not odd %}number is even{% endif %}
+{% for item in simple.multiple_item_list if item is divisibleby 3 %}{{ item }} {% endfor %}
+{{ "yes" if simple.missing is not defined else "no" }}
+{% with even=simple.number is divisibleby 2 odd=simple.number is odd %}{{ even }}/{{ odd }}{% endwith %}
@@ -0,0 +1,9 @@
+a  {{- "b" -}}  c
+{%- if true -%}
+    d
+{%- endif %}
+[ {#- comment -#} ]
+{% for i in simple.multiple_item_list -%}
+    {{ i }}
+{%- endfor %}
+{{ 5 - 3 }} {{ -1 }}
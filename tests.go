@@ -0,0 +1,64 @@
+package pongo2
+
+import (
+	"sync"
+
+	"github.com/juju/errors"
+)
+
+// TestFunction is the type test functions must fulfil. Tests are used with
+// the is-operator, e. g. {% if x is divisibleby(3) %} or {% if x is not defined %};
+// in is the tested value and params contains the (optional) arguments.
+type TestFunction func(in *Value, params []*Value) (bool, *Error)
+
+type test struct {
+	name string
+	fn   TestFunction
+}
+
+var (
+	tests      map[string]*test
+	testsMutex sync.RWMutex
+)
+
+func init() {
+	tests = make(map[string]*test)
+}
+
+// TestExists returns true if the given test is already registered
+func TestExists(name string) bool {
+	_, existing := getTest(name)
+	return existing
+}
+
+func getTest(name string) (*test, bool) {
+	testsMutex.RLock()
+	defer testsMutex.RUnlock()
+	t, existing := tests[name]
+	return t, existing
+}
+
+// RegisterTest registers a new test. If there's already a test with the same
+// name, RegisterTest will return an error. You usually want to call this
+// function in the test's init() function.
+func RegisterTest(name string, fn TestFunction) error {
+	testsMutex.Lock()
+	defer testsMutex.Unlock()
+	if _, existing := tests[name]; existing {
+		return errors.Errorf("test with name '%s' is already registered", name)
+	}
+	tests[name] = &test{name: name, fn: fn}
+	return nil
+}
+
+// ReplaceTest replaces an already registered test with a new implementation. Use this
+// function with caution since it allows you to change existing test behaviour.
+func ReplaceTest(name string, fn TestFunction) error {
+	testsMutex.Lock()
+	defer testsMutex.Unlock()
+	if _, existing := tests[name]; !existing {
+		return errors.Errorf("test with name '%s' does not exist (therefore cannot be overridden)", name)
+	}
+	tests[name] = &test{name: name, fn: fn}
+	return nil
+}
@@ -0,0 +1,123 @@
+package pongo2
+
+import (
+	"reflect"
+
+	"github.com/juju/errors"
+)
+
+func init() {
+	RegisterTest("defined", testDefined)
+	RegisterTest("none", testNone)
+	RegisterTest("number", testNumber)
+	RegisterTest("string", testString)
+	RegisterTest("iterable", testIterable)
+	RegisterTest("mapping", testMapping)
+	RegisterTest("even", testEven)
+	RegisterTest("odd", testOdd)
+	RegisterTest("divisibleby", testDivisibleby)
+	RegisterTest("sameas", testSameas)
+	RegisterTest("in", testIn)
+	RegisterTest("empty", testEmpty)
+}
+
+// testParam returns the only argument of a test.
+func testParam(name string, params []*Value) (*Value, *Error) {
+	if len(params) != 1 {
+		return nil, &Error{
+			Sender:    "test:" + name,
+			OrigError: errors.Errorf("test '%s' requires exactly one argument (got %d)", name, len(params)),
+		}
+	}
+	return params[0], nil
+}
+
+func testDefined(in *Value, params []*Value) (bool, *Error) {
+	return !in.IsUndefined(), nil
+}
+
+func testNone(in *Value, params []*Value) (bool, *Error) {
+	return in.IsNil() && !in.IsUndefined(), nil
+}
+
+func testNumber(in *Value, params []*Value) (bool, *Error) {
+	return in.IsNumber(), nil
+}
+
+func testString(in *Value, params []*Value) (bool, *Error) {
+	return in.IsString(), nil
+}
+
+func testIterable(in *Value, params []*Value) (bool, *Error) {
+	switch in.getResolvedValue().Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.String:
+		return true, nil
+	}
+	return false, nil
+}
+
+func testMapping(in *Value, params []*Value) (bool, *Error) {
+	return in.getResolvedValue().Kind() == reflect.Map, nil
+}
+
+func testEven(in *Value, params []*Value) (bool, *Error) {
+	return in.IsInteger() && in.Integer()%2 == 0, nil
+}
+
+func testOdd(in *Value, params []*Value) (bool, *Error) {
+	return in.IsInteger() && in.Integer()%2 != 0, nil
+}
+
+func testDivisibleby(in *Value, params []*Value) (bool, *Error) {
+	param, err := testParam("divisibleby", params)
+	if err != nil {
+		return false, err
+	}
+	if !in.IsInteger() || !param.IsInteger() || param.Integer() == 0 {
+		return false, nil
+	}
+	return in.Integer()%param.Integer() == 0, nil
+}
+
+func testSameas(in *Value, params []*Value) (bool, *Error) {
+	param, err := testParam("sameas", params)
+	if err != nil {
+		return false, err
+	}
+	if in.IsNil() || param.IsNil() {
+		return in.IsNil() && param.IsNil(), nil
+	}
+
+	// References are the same if they point to the same object
+	v1 := reflect.ValueOf(in.Interface())
+	v2 := reflect.ValueOf(param.Interface())
+	if v1.Type() != v2.Type() {
+		return false, nil
+	}
+	switch v1.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Func, reflect.Chan:
+		return v1.Pointer() == v2.Pointer(), nil
+	case reflect.Slice:
+		return v1.Pointer() == v2.Pointer() && v1.Len() == v2.Len(), nil
+	}
+	return in.EqualValueTo(param), nil
+}
+
+func testIn(in *Value, params []*Value) (bool, *Error) {
+	param, err := testParam("in", params)
+	if err != nil {
+		return false, err
+	}
+	return param.Contains(in), nil
+}
+
+func testEmpty(in *Value, params []*Value) (bool, *Error) {
+	if in.IsNil() {
+		return true, nil
+	}
+	switch in.getResolvedValue().Kind() {
+	case reflect.Array, reflect.Slice, reflect.Map, reflect.String, reflect.Chan:
+		return in.Len() == 0, nil
+	}
+	return false, nil
+}
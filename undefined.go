@@ -0,0 +1,171 @@
+package pongo2
+
+import (
+	"fmt"
+)
+
+// UndefinedPolicy defines how undefined variables are handled during the
+// execution of a template (see TemplateSet.Undefined). A variable is undefined
+// if a context key or struct field does not exist, if an index is out of
+// range or if a nil pointer is accessed.
+type UndefinedPolicy int
+
+const (
+	// UndefinedSilent evaluates undefined variables to nil (they render
+	// as an empty string). This is the default.
+	UndefinedSilent UndefinedPolicy = iota
+
+	// UndefinedDebug renders a visible marker (e. g. "{{ usr.name }}")
+	// instead of the output of a variable tag using an undefined variable;
+	// they still evaluate to nil otherwise (e. g. within filters).
+	UndefinedDebug
+
+	// UndefinedStrict aborts the execution with an *Error whose OrigError
+	// is an *UndefinedError.
+	UndefinedStrict
+)
+
+// UndefinedHandler is called for every undefined variable if it's set
+// (see TemplateSet.UndefinedHandler). variable is the path of the variable
+// up to (and including) the segment which could not be resolved. The returned
+// value is used instead of the undefined variable; a returned error aborts
+// the execution.
+type UndefinedHandler func(ctx *ExecutionContext, variable, segment string) (*Value, error)
+
+// UndefinedError is used as the OrigError of an *Error if an undefined
+// variable is accessed in strict mode (see UndefinedStrict).
+type UndefinedError struct {
+	Variable string // the path up to (and including) the failing segment, e. g. "usr.name"
+	Segment  string // the failing segment, e. g. "name"
+}
+
+func (e *UndefinedError) Error() string {
+	if e.Variable == e.Segment {
+		return fmt.Sprintf("'%s' is undefined", e.Variable)
+	}
+	return fmt.Sprintf("'%s' is undefined (cannot resolve '%s')", e.Variable, e.Segment)
+}
+
+// undefined is called by the variable resolver whenever the part with the
+// given index could not be resolved.
+func (vr *variableResolver) undefined(ctx *ExecutionContext, idx int) (*Value, *Error) {
+	// Conditions like {% if user %}, tests like 'defined' and the default
+	// filters are expected to work with undefined variables
+	if ctx.ignoreUndefined || ctx.isTruthOperand(vr) {
+		return &Value{isUndefined: true}, nil
+	}
+
+	set := ctx.template.set
+	if set.UndefinedHandler == nil && set.Undefined == UndefinedSilent {
+		return &Value{isUndefined: true}, nil
+	}
+
+	part := vr.parts[idx]
+	token := part.token
+	if token == nil {
+		token = vr.locationToken
+	}
+	variable := (&variableResolver{parts: vr.parts[:idx+1]}).String()
+	segment := (&variableResolver{parts: vr.parts[idx : idx+1]}).String()
+
+	if set.UndefinedHandler != nil {
+		value, err := set.UndefinedHandler(ctx, variable, segment)
+		if err != nil {
+			return nil, ctx.OrigError(err, token)
+		}
+		if value == nil {
+			value = AsValue(nil)
+		}
+		return value, nil
+	}
+
+	switch set.Undefined {
+	case UndefinedDebug:
+		// Rendered instead of the output of the variable tag (see nodeVariable)
+		if ctx.undefinedMarker == "" {
+			ctx.undefinedMarker = fmt.Sprintf("{{ %s }}", variable)
+		}
+	case UndefinedStrict:
+		return nil, ctx.OrigError(&UndefinedError{Variable: variable, Segment: segment}, token)
+	}
+	return &Value{isUndefined: true}, nil
+}
+
+// evaluateIgnoringUndefined evaluates expr treating undefined variables as
+// nil, regardless of the set's UndefinedPolicy.
+func (ctx *ExecutionContext) evaluateIgnoringUndefined(expr IEvaluator) (*Value, *Error) {
+	previous := ctx.ignoreUndefined
+	ctx.ignoreUndefined = true
+	defer func() { ctx.ignoreUndefined = previous }()
+	return expr.Evaluate(ctx)
+}
+
+// evaluateCondition evaluates the condition of tags like {% if %} and
+// {% firstof %}. Variables used directly as truth values (like `user` in
+// `{% if user and not user.is_admin %}`) are treated as nil if they're
+// undefined; all other variables (e. g. the operands of comparisons,
+// arithmetics and function calls) are handled according to the set's
+// UndefinedPolicy. operands are the truth values of expr as returned by
+// truthOperands (determined once while parsing).
+func (ctx *ExecutionContext) evaluateCondition(expr IEvaluator, operands []*variableResolver) (*Value, *Error) {
+	set := ctx.template.set
+	if len(operands) == 0 || (set.Undefined != UndefinedStrict && set.UndefinedHandler == nil) {
+		// Undefined truth values wouldn't be treated differently anyway
+		return expr.Evaluate(ctx)
+	}
+
+	previous := ctx.truthOperands
+	ctx.truthOperands = operands
+	defer func() { ctx.truthOperands = previous }()
+	return expr.Evaluate(ctx)
+}
+
+func (ctx *ExecutionContext) isTruthOperand(vr *variableResolver) bool {
+	for _, operand := range ctx.truthOperands {
+		if operand == vr {
+			return true
+		}
+	}
+	return false
+}
+
+// truthOperands appends all variables of expr which are used directly as
+// truth values, i. e. which are only combined using and, or and not.
+func truthOperands(operands []*variableResolver, expr IEvaluator) []*variableResolver {
+	switch e := expr.(type) {
+	case *Expression:
+		// and/or
+		operands = truthOperands(operands, e.expr1)
+		if e.expr2 != nil {
+			operands = truthOperands(operands, e.expr2)
+		}
+	case *relationalExpression:
+		if e.expr2 == nil && e.test == nil {
+			operands = truthOperands(operands, e.expr1)
+		}
+	case *simpleExpression:
+		if e.term2 == nil && !e.negativeSign {
+			operands = truthOperands(operands, e.term1)
+		}
+	case *term:
+		if e.factor2 == nil {
+			operands = truthOperands(operands, e.factor1)
+		}
+	case *power:
+		if e.power2 == nil {
+			operands = truthOperands(operands, e.power1)
+		}
+	case *nodeFilteredVariable:
+		if len(e.filterChain) == 0 {
+			operands = truthOperands(operands, e.resolver)
+		}
+	case *variableResolver:
+		for _, part := range e.parts {
+			if part.isFunctionCall {
+				return operands
+			}
+		}
+		operands = append(operands, e)
+	}
+	return operands
+}
@@ -11,6 +11,8 @@ import (
 type Value struct {
 	val  reflect.Value
 	safe bool // used to indicate whether a Value needs explicit escaping in the template
+
+	isUndefined bool // the value results from an undefined variable
 }
 
 // AsValue converts any given value to a pongo2.Value
@@ -76,6 +78,12 @@ func (v *Value) IsNumber() bool {
 	return v.IsInteger() || v.IsFloat()
 }
 
+// IsUndefined checks whether the value results from an undefined variable
+// (see UndefinedPolicy). Undefined values are NIL as well.
+func (v *Value) IsUndefined() bool {
+	return v.isUndefined
+}
+
 // IsNil checks whether the underlying value is NIL
 func (v *Value) IsNil() bool {
 	//fmt.Printf("%+v\n", v.getResolvedValue().Type().String())
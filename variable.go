@@ -2,6 +2,7 @@ package pongo2
 
 import (
 	"fmt"
+	"math"
 	"reflect"
 	"strconv"
 	"strings"
@@ -12,6 +13,8 @@ import (
 const (
 	varTypeInt = iota
 	varTypeIdent
+	varTypeSubscript // [expr]
+	varTypeSlice     // [expr:expr]
 )
 
 var (
@@ -20,12 +23,19 @@ var (
 )
 
 type variablePart struct {
-	typ int
-	s   string
-	i   int
-
-	isFunctionCall bool
-	callingArgs    []functionCallArgument // needed for a function call, represents all argument nodes (INode supports nested function calls)
+	token *Token
+	typ   int
+	s     string
+	i     int
+
+	isFunctionCall    bool
+	callingArgs       []functionCallArgument // needed for a function call, represents all argument nodes (INode supports nested function calls)
+	callingKwargNames []string               // keyword arguments of a function call (name=expr)
+	callingKwargs     []functionCallArgument
+
+	// Subscripts and slices; s contains the source of the subscript (e. g. `["key"]`)
+	subscript IEvaluator // also the start of a slice (nil if omitted)
+	sliceEnd  IEvaluator // nil if omitted
 }
 
 type functionCallArgument interface {
@@ -151,6 +161,24 @@ func (b *boolResolver) GetPositionToken() *Token {
 	return b.locationToken
 }
 
+func (v *nodeFilteredVariable) Children() []Node {
+	return appendNodes(nil, v.resolver, v.filterChain)
+}
+
+func (vr *variableResolver) Children() []Node {
+	var children []Node
+	for _, part := range vr.parts {
+		children = appendNodes(children, part.callingArgs, part.callingKwargs, part.subscript, part.sliceEnd)
+	}
+	return children
+}
+
+// VariableName returns the variable as written in the template (without
+// any function call arguments), e. g. user.name or items[0].
+func (vr *variableResolver) VariableName() string {
+	return vr.String()
+}
+
 func (s *stringResolver) Evaluate(ctx *ExecutionContext) (*Value, *Error) {
 	return AsValue(s.val), nil
 }
@@ -183,19 +211,37 @@ func (b *boolResolver) FilterApplied(name string) bool {
 	return false
 }
 
+func (nv *nodeVariable) GetPositionToken() *Token {
+	return nv.locationToken
+}
+
+func (nv *nodeVariable) Children() []Node {
+	return appendNodes(nil, nv.expr)
+}
+
 func (nv *nodeVariable) FilterApplied(name string) bool {
 	return nv.expr.FilterApplied(name)
 }
 
 func (nv *nodeVariable) Execute(ctx *ExecutionContext, writer TemplateWriter) *Error {
+	previous := ctx.undefinedMarker
+	ctx.undefinedMarker = ""
 	value, err := nv.expr.Evaluate(ctx)
+	marker := ctx.undefinedMarker
+	ctx.undefinedMarker = previous
 	if err != nil {
 		return err
 	}
 
+	if marker != "" {
+		// An undefined variable has been used (see UndefinedDebug)
+		writer.WriteString(marker)
+		return nil
+	}
+
 	if !nv.expr.FilterApplied("safe") && !value.safe && value.IsString() && ctx.Autoescape {
 		// apply escape filter
-		value, err = filters["escape"](value, nil)
+		value, err = ctx.escape(value)
 		if err != nil {
 			return err
 		}
@@ -214,24 +260,41 @@ func (vr *variableResolver) FilterApplied(name string) bool {
 }
 
 func (vr *variableResolver) String() string {
-	parts := make([]string, 0, len(vr.parts))
-	for _, p := range vr.parts {
+	var b strings.Builder
+	for idx, p := range vr.parts {
 		switch p.typ {
 		case varTypeInt:
-			parts = append(parts, strconv.Itoa(p.i))
+			if idx > 0 {
+				b.WriteString(".")
+			}
+			b.WriteString(strconv.Itoa(p.i))
 		case varTypeIdent:
-			parts = append(parts, p.s)
+			if idx > 0 {
+				b.WriteString(".")
+			}
+			b.WriteString(p.s)
+		case varTypeSubscript, varTypeSlice:
+			b.WriteString(p.s)
 		default:
 			panic("unimplemented")
 		}
 	}
-	return strings.Join(parts, ".")
+	return b.String()
 }
 
 func (vr *variableResolver) resolve(ctx *ExecutionContext) (*Value, error) {
 	var current reflect.Value
 	var isSafe bool
 
+	// Handles an unresolvable part according to the set's UndefinedPolicy
+	undefined := func(idx int) (*Value, error) {
+		value, err := vr.undefined(ctx, idx)
+		if err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+
 	for idx, part := range vr.parts {
 		if idx == 0 {
 			// We're looking up the first part of the variable.
@@ -240,7 +303,11 @@ func (vr *variableResolver) resolve(ctx *ExecutionContext) (*Value, error) {
 			val, inPrivate := ctx.Private[vr.parts[0].s]
 			if !inPrivate {
 				// Nothing found? Then have a final lookup in the public context
-				val = ctx.Public[vr.parts[0].s]
+				var inPublic bool
+				val, inPublic = ctx.Public[vr.parts[0].s]
+				if !inPublic {
+					return undefined(idx)
+				}
 			}
 			current = reflect.ValueOf(val) // Get the initial value
 		} else {
@@ -263,7 +330,7 @@ func (vr *variableResolver) resolve(ctx *ExecutionContext) (*Value, error) {
 					current = current.Elem()
 					if !current.IsValid() {
 						// Value is not valid (anymore)
-						return AsValue(nil), nil
+						return undefined(idx)
 					}
 				}
 
@@ -272,13 +339,16 @@ func (vr *variableResolver) resolve(ctx *ExecutionContext) (*Value, error) {
 				case varTypeInt:
 					// Calling an index is only possible for:
 					// * slices/arrays/strings
+					// * maps (with a key of the same value)
 					switch current.Kind() {
+					case reflect.Map:
+						current = mapIndex(current, AsValue(part.i))
 					case reflect.String, reflect.Array, reflect.Slice:
 						if part.i >= 0 && current.Len() > part.i {
 							current = current.Index(part.i)
 						} else {
 							// In Django, exceeding the length of a list is just empty.
-							return AsValue(nil), nil
+							return undefined(idx)
 						}
 					default:
 						return nil, errors.Errorf("Can't access an index on type %s (variable %s)",
@@ -293,11 +363,66 @@ func (vr *variableResolver) resolve(ctx *ExecutionContext) (*Value, error) {
 					case reflect.Struct:
 						current = current.FieldByName(part.s)
 					case reflect.Map:
-						current = current.MapIndex(reflect.ValueOf(part.s))
+						current = mapIndex(current, AsValue(part.s))
 					default:
 						return nil, errors.Errorf("Can't access a field by name on type %s (variable %s)",
 							current.Kind().String(), vr.String())
 					}
+				case varTypeSubscript:
+					key, err := part.subscript.Evaluate(ctx)
+					if err != nil {
+						return nil, err
+					}
+
+					switch current.Kind() {
+					case reflect.String, reflect.Array, reflect.Slice:
+						if !key.IsInteger() {
+							return nil, errors.Errorf("Index of %s must be an integer, got '%s' (variable %s)",
+								current.Kind().String(), key.String(), vr.String())
+						}
+						length := (&Value{val: current}).Len()
+						i := key.Integer()
+						if i < 0 {
+							// Negative indexes are counted from the end
+							i += length
+						}
+						if i < 0 || i >= length {
+							return undefined(idx)
+						}
+						if current.Kind() == reflect.String {
+							current = (&Value{val: current}).Index(i).val
+						} else {
+							current = current.Index(i)
+						}
+					case reflect.Map:
+						current = mapIndex(current, key)
+					case reflect.Struct:
+						current = current.FieldByName(key.String())
+					default:
+						return nil, errors.Errorf("Can't access a subscript on type %s (variable %s)",
+							current.Kind().String(), vr.String())
+					}
+				case varTypeSlice:
+					switch current.Kind() {
+					case reflect.String, reflect.Array, reflect.Slice:
+					default:
+						return nil, errors.Errorf("Can't slice type %s (variable %s)",
+							current.Kind().String(), vr.String())
+					}
+
+					length := (&Value{val: current}).Len()
+					start, err := sliceBound(ctx, part.subscript, 0, length)
+					if err != nil {
+						return nil, err
+					}
+					end, err := sliceBound(ctx, part.sliceEnd, length, length)
+					if err != nil {
+						return nil, err
+					}
+					if end < start {
+						end = start
+					}
+					current = (&Value{val: current}).Slice(start, end).val
 				default:
 					panic("unimplemented")
 				}
@@ -306,6 +431,10 @@ func (vr *variableResolver) resolve(ctx *ExecutionContext) (*Value, error) {
 
 		if !current.IsValid() {
 			// Value is not valid (anymore)
+			if idx > 0 {
+				// Field or key does not exist
+				return undefined(idx)
+			}
 			return AsValue(nil), nil
 		}
 
@@ -324,7 +453,19 @@ func (vr *variableResolver) resolve(ctx *ExecutionContext) (*Value, error) {
 		}
 
 		// Check if the part is a function call
-		if part.isFunctionCall || current.Kind() == reflect.Func {
+		if macro, isMacro := asMacroFunction(current); isMacro {
+			// Macros support keyword arguments and return proper errors
+			args, kwargs, err := part.evaluateCallArguments(ctx)
+			if err != nil {
+				return nil, err
+			}
+			value, merr := macro(args, kwargs)
+			if merr != nil {
+				return nil, merr.updateFromTokenIfNeeded(ctx.template, part.token)
+			}
+			current = value.val
+			isSafe = value.safe
+		} else if part.isFunctionCall || current.Kind() == reflect.Func {
 			// Check for callable
 			if current.Kind() != reflect.Func {
 				return nil, errors.Errorf("'%s' is not a function (it is %s)", vr.String(), current.Kind().String())
@@ -340,11 +481,33 @@ func (vr *variableResolver) resolve(ctx *ExecutionContext) (*Value, error) {
 				currArgs = append([]functionCallArgument{executionCtxEval{}}, currArgs...)
 			}
 
+			// Keyword arguments are mapped onto a trailing struct or map[string]*Value
+			// parameter. Without keyword arguments only a map[string]*Value is
+			// passed implicitly (empty); a struct is an ordinary parameter then.
+			numIn := t.NumIn()
+			var kwargsParameter reflect.Value
+			if len(part.callingKwargs) > 0 || (len(currArgs) == numIn-1 && !t.IsVariadic() && t.In(numIn-1) == typeOfKwargsMap) {
+				if numIn == 0 || t.IsVariadic() || !isKwargsParameter(t.In(numIn-1)) {
+					return nil, errors.Errorf("'%s' does not accept keyword arguments (its last parameter must be a struct or a map[string]*pongo2.Value).",
+						vr.String())
+				}
+				kwargs, err := part.evaluateKwargs(ctx)
+				if err != nil {
+					return nil, err
+				}
+				var perr error
+				kwargsParameter, perr = newKwargsParameter(t.In(numIn-1), kwargs)
+				if perr != nil {
+					return nil, errors.Errorf("%s (function '%s')", perr, vr.String())
+				}
+				numIn--
+			}
+
 			// Input arguments
-			if len(currArgs) != t.NumIn() && !(len(currArgs) >= t.NumIn()-1 && t.IsVariadic()) {
+			if len(currArgs) != numIn && !(len(currArgs) >= numIn-1 && t.IsVariadic()) {
 				return nil,
 					errors.Errorf("Function input argument count (%d) of '%s' must be equal to the calling argument count (%d).",
-						t.NumIn(), vr.String(), len(currArgs))
+						numIn, vr.String(), len(currArgs))
 			}
 
 			// Output arguments
@@ -398,6 +561,10 @@ func (vr *variableResolver) resolve(ctx *ExecutionContext) (*Value, error) {
 				}
 			}
 
+			if kwargsParameter.IsValid() {
+				parameters = append(parameters, kwargsParameter)
+			}
+
 			// Check if any of the values are invalid
 			for _, p := range parameters {
 				if p.Kind() == reflect.Invalid {
@@ -426,9 +593,226 @@ func (vr *variableResolver) resolve(ctx *ExecutionContext) (*Value, error) {
 	return &Value{val: current, safe: isSafe}, nil
 }
 
+// evaluateCallArguments evaluates the positional and keyword arguments of a function call.
+func (part *variablePart) evaluateCallArguments(ctx *ExecutionContext) ([]*Value, map[string]*Value, *Error) {
+	args := make([]*Value, 0, len(part.callingArgs))
+	for _, arg := range part.callingArgs {
+		value, err := arg.Evaluate(ctx)
+		if err != nil {
+			return nil, nil, err
+		}
+		args = append(args, value)
+	}
+	kwargs, err := part.evaluateKwargs(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	return args, kwargs, nil
+}
+
+func (part *variablePart) evaluateKwargs(ctx *ExecutionContext) (map[string]*Value, *Error) {
+	kwargs := make(map[string]*Value, len(part.callingKwargs))
+	for idx, kwarg := range part.callingKwargs {
+		value, err := kwarg.Evaluate(ctx)
+		if err != nil {
+			return nil, err
+		}
+		kwargs[part.callingKwargNames[idx]] = value
+	}
+	return kwargs, nil
+}
+
+var typeOfKwargsMap = reflect.TypeOf(map[string]*Value{})
+
+// isKwargsParameter reports whether keyword arguments can be mapped onto a
+// function parameter of the given type.
+func isKwargsParameter(t reflect.Type) bool {
+	return t == typeOfKwargsMap || t.Kind() == reflect.Struct
+}
+
+// newKwargsParameter creates the value of a function parameter (see
+// isKwargsParameter) holding the given keyword arguments. Keyword arguments
+// are mapped onto struct fields by the field's pongo2 tag (like `pongo2:"name"`)
+// or by the field's name (case-insensitive).
+func newKwargsParameter(t reflect.Type, kwargs map[string]*Value) (reflect.Value, error) {
+	if t == typeOfKwargsMap {
+		return reflect.ValueOf(kwargs), nil
+	}
+
+	param := reflect.New(t).Elem()
+	for name, value := range kwargs {
+		field, found := kwargsField(t, name)
+		if !found {
+			return reflect.Value{}, errors.Errorf("unknown keyword argument '%s'", name)
+		}
+		fieldValue := param.FieldByIndex(field.Index)
+
+		if field.Type == typeOfValuePtr {
+			fieldValue.Set(reflect.ValueOf(value))
+			continue
+		}
+		v := reflect.ValueOf(value.Interface())
+		if !v.IsValid() {
+			continue // nil, keep the zero value
+		}
+		switch {
+		case v.Type().AssignableTo(field.Type):
+			fieldValue.Set(v)
+		case value.IsNumber() && v.Type().ConvertibleTo(field.Type) && field.Type.Kind() != reflect.String:
+			fieldValue.Set(v.Convert(field.Type))
+		default:
+			return reflect.Value{}, errors.Errorf("keyword argument '%s' must be of type %s or *pongo2.Value (not %s)",
+				name, field.Type.String(), v.Type().String())
+		}
+	}
+	return param, nil
+}
+
+func kwargsField(t reflect.Type, name string) (reflect.StructField, bool) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue // unexported
+		}
+		if tag := field.Tag.Get("pongo2"); tag != "" {
+			if tag == name {
+				return field, true
+			}
+			continue
+		}
+		if strings.EqualFold(field.Name, name) {
+			return field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
+
+// asMacroFunction returns the macro (see tagMacroNode) if v is one.
+func asMacroFunction(v reflect.Value) (macroFunction, bool) {
+	if !v.IsValid() || v.Kind() != reflect.Func || !v.CanInterface() {
+		return nil, false
+	}
+	macro, isMacro := v.Interface().(macroFunction)
+	return macro, isMacro
+}
+
+// mapIndex looks up key in the map m. The key is converted to the key type
+// of the map where possible (e. g. to int64 or to a custom string type).
+// It returns an invalid reflect.Value if there's no such key, which includes
+// numbers not representable by the key type (e. g. 257 or 1.5 for uint8 keys).
+func mapIndex(m reflect.Value, key *Value) reflect.Value {
+	keyType := m.Type().Key()
+	var k reflect.Value
+	switch keyType.Kind() {
+	case reflect.String:
+		k = reflect.ValueOf(key.String())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := intKey(key)
+		if !ok || reflect.Zero(keyType).OverflowInt(n) {
+			return reflect.Value{}
+		}
+		k = reflect.ValueOf(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, ok := uintKey(key)
+		if !ok || reflect.Zero(keyType).OverflowUint(n) {
+			return reflect.Value{}
+		}
+		k = reflect.ValueOf(n)
+	case reflect.Float32, reflect.Float64:
+		if !key.IsNumber() || reflect.Zero(keyType).OverflowFloat(key.Float()) {
+			return reflect.Value{}
+		}
+		k = reflect.ValueOf(key.Float())
+	default:
+		k = key.getResolvedValue()
+		if !k.IsValid() {
+			return reflect.Value{}
+		}
+	}
+
+	if !k.Type().AssignableTo(keyType) {
+		if !k.Type().ConvertibleTo(keyType) {
+			return reflect.Value{}
+		}
+		k = k.Convert(keyType)
+	}
+	return m.MapIndex(k)
+}
+
+// intKey returns the number key as int64; floats must not have a fractional part.
+func intKey(key *Value) (int64, bool) {
+	rv := key.getResolvedValue()
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return rv.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if rv.Uint() > math.MaxInt64 {
+			return 0, false
+		}
+		return int64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	return 0, false
+}
+
+// uintKey returns the number key as uint64; floats must not have a fractional part.
+func uintKey(key *Value) (uint64, bool) {
+	rv := key.getResolvedValue()
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if rv.Int() < 0 {
+			return 0, false
+		}
+		return uint64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return rv.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		f := rv.Float()
+		if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return 0, false
+		}
+		return uint64(f), true
+	}
+	return 0, false
+}
+
+// sliceBound evaluates a bound of a slice (Python-style: negative bounds
+// are counted from the end, bounds exceeding the length are clamped).
+func sliceBound(ctx *ExecutionContext, expr IEvaluator, def, length int) (int, error) {
+	if expr == nil {
+		return def, nil
+	}
+	v, err := expr.Evaluate(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if !v.IsInteger() {
+		return 0, errors.Errorf("Slice bounds must be integers, got '%s'", v.String())
+	}
+	i := v.Integer()
+	if i < 0 {
+		i += length
+	}
+	if i < 0 {
+		i = 0
+	}
+	if i > length {
+		i = length
+	}
+	return i, nil
+}
+
 func (vr *variableResolver) Evaluate(ctx *ExecutionContext) (*Value, *Error) {
 	value, err := vr.resolve(ctx)
 	if err != nil {
+		if perr, ok := err.(*Error); ok {
+			return AsValue(nil), perr
+		}
 		return AsValue(nil), ctx.Error(err.Error(), vr.locationToken)
 	}
 	return value, nil
@@ -444,7 +828,14 @@ func (v *nodeFilteredVariable) FilterApplied(name string) bool {
 }
 
 func (v *nodeFilteredVariable) Evaluate(ctx *ExecutionContext) (*Value, *Error) {
-	value, err := v.resolver.Evaluate(ctx)
+	var value *Value
+	var err *Error
+	if v.FilterApplied("default") || v.FilterApplied("default_if_none") {
+		// The default filters are expected to work with undefined variables
+		value, err = ctx.evaluateIgnoringUndefined(v.resolver)
+	} else {
+		value, err = v.resolver.Evaluate(ctx)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -528,6 +919,13 @@ func (p *Parser) parseVariableOrLiteral() (IEvaluator, *Error) {
 		default:
 			return nil, p.Error("This keyword is not allowed here.", nil)
 		}
+	case TokenSymbol:
+		switch t.Val {
+		case "[":
+			return p.parseListLiteral()
+		case "{":
+			return p.parseMapLiteral()
+		}
 	}
 
 	resolver := &variableResolver{
@@ -540,8 +938,9 @@ func (p *Parser) parseVariableOrLiteral() (IEvaluator, *Error) {
 	}
 
 	resolver.parts = append(resolver.parts, &variablePart{
-		typ: varTypeIdent,
-		s:   t.Val,
+		token: t,
+		typ:   varTypeIdent,
+		s:     t.Val,
 	})
 
 	p.Consume() // we consumed the first identifier of the variable name
@@ -557,8 +956,9 @@ variableLoop:
 				switch t2.Typ {
 				case TokenIdentifier:
 					resolver.parts = append(resolver.parts, &variablePart{
-						typ: varTypeIdent,
-						s:   t2.Val,
+						token: t2,
+						typ:   varTypeIdent,
+						s:     t2.Val,
 					})
 					p.Consume() // consume: IDENT
 					continue variableLoop
@@ -568,8 +968,9 @@ variableLoop:
 						return nil, p.Error(err.Error(), t2)
 					}
 					resolver.parts = append(resolver.parts, &variablePart{
-						typ: varTypeInt,
-						i:   i,
+						token: t2,
+						typ:   varTypeInt,
+						i:     i,
 					})
 					p.Consume() // consume: NUMBER
 					continue variableLoop
@@ -581,6 +982,14 @@ variableLoop:
 				return nil, p.Error("Unexpected EOF, expected either IDENTIFIER or NUMBER after DOT.",
 					p.lastToken)
 			}
+		} else if p.Peek(TokenSymbol, "[") != nil {
+			// Subscript or slice: '[' expr ']' or '[' [expr] ':' [expr] ']'
+			part, err := p.parseSubscript()
+			if err != nil {
+				return nil, err
+			}
+			resolver.parts = append(resolver.parts, part)
+			continue variableLoop
 		} else if p.Match(TokenSymbol, "(") != nil {
 			// Function call
 			// FunctionName '(' Comma-separated list of expressions ')'
@@ -593,12 +1002,31 @@ variableLoop:
 				}
 
 				if p.Peek(TokenSymbol, ")") == nil {
-					// No closing bracket, so we're parsing an expression
-					exprArg, err := p.ParseExpression()
-					if err != nil {
-						return nil, err
+					// No closing bracket, so we're parsing an expression (name=expression for keyword arguments)
+					if p.PeekType(TokenIdentifier) != nil && p.PeekN(1, TokenSymbol, "=") != nil {
+						nameToken := p.Current()
+						p.ConsumeN(2)
+						for _, name := range part.callingKwargNames {
+							if name == nameToken.Val {
+								return nil, p.Error(fmt.Sprintf("Keyword argument '%s' given twice.", name), nameToken)
+							}
+						}
+						exprArg, err := p.ParseExpression()
+						if err != nil {
+							return nil, err
+						}
+						part.callingKwargNames = append(part.callingKwargNames, nameToken.Val)
+						part.callingKwargs = append(part.callingKwargs, exprArg)
+					} else {
+						if len(part.callingKwargs) > 0 {
+							return nil, p.Error("Positional arguments must not follow keyword arguments.", nil)
+						}
+						exprArg, err := p.ParseExpression()
+						if err != nil {
+							return nil, err
+						}
+						part.callingArgs = append(part.callingArgs, exprArg)
 					}
-					part.callingArgs = append(part.callingArgs, exprArg)
 
 					if p.Match(TokenSymbol, ")") != nil {
 						// If there's a closing bracket after an expression, we will stop parsing the arguments
@@ -627,6 +1055,54 @@ variableLoop:
 	return resolver, nil
 }
 
+func (p *Parser) parseSubscript() (*variablePart, *Error) {
+	start := p.idx
+	part := &variablePart{
+		token: p.Current(),
+		typ:   varTypeSubscript,
+	}
+	p.Consume() // consume '['
+
+	if p.Peek(TokenSymbol, "]") != nil {
+		return nil, p.Error("Expected an expression within the subscript.", nil)
+	}
+	if p.Peek(TokenSymbol, ":") == nil {
+		subscript, err := p.ParseExpression()
+		if err != nil {
+			return nil, err
+		}
+		part.subscript = subscript
+	}
+
+	if p.Match(TokenSymbol, ":") != nil {
+		part.typ = varTypeSlice
+		if p.Peek(TokenSymbol, "]") == nil {
+			sliceEnd, err := p.ParseExpression()
+			if err != nil {
+				return nil, err
+			}
+			part.sliceEnd = sliceEnd
+		}
+	}
+
+	if p.Match(TokenSymbol, "]") == nil {
+		return nil, p.Error("Expected ']' to close the subscript.", nil)
+	}
+
+	// Keep the source for error messages (e. g. strict undefined variables)
+	var src strings.Builder
+	for _, t := range p.tokens[start:p.idx] {
+		if t.Typ == TokenString {
+			src.WriteString(strconv.Quote(t.Val))
+		} else {
+			src.WriteString(t.Val)
+		}
+	}
+	part.s = src.String()
+
+	return part, nil
+}
+
 func (p *Parser) parseVariableOrLiteralWithFilter() (*nodeFilteredVariable, *Error) {
 	v := &nodeFilteredVariable{
 		locationToken: p.Current(),